@@ -7,7 +7,7 @@ import (
 	"fmt"
 
 	"github.com/Azure/go-autorest/autorest/to"
-	"github.com/naveego/beacon-go"
+	"github.com/naveego/beacon-go/pkg/beacon"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/types"
@@ -86,10 +86,10 @@ var _ = Describe("System", func() {
 
 		It("should start system", func() {
 			sut = client.StartSystem(beacon.SystemOptions{
-				Name:                "system",
-				Tenant:              "naveego",
-				DisplayName:         "Test System",
-				FeatureInstancePath: *featureInstance.Path,
+				Name:               "system",
+				Tenant:             "naveego",
+				DisplayName:        "Test System",
+				FeatureInstanceNRN: beacon.FeatureInstanceNRN("naveego", featureName, featureVersion, instanceName),
 			}, log)
 
 			Expect(sut).To(BeRealSystem())
@@ -0,0 +1,149 @@
+package beacon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// ConfigHash returns the identifier reported as a system's ActiveConfig for
+// a feature instance config, like "sha256:3b5d...". Configs which are equal
+// as JSON have the same hash, regardless of key order.
+func ConfigHash(config interface{}) (string, error) {
+	normalized, err := normalizeJSON(config, false)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// activeConfig returns the ConfigHash of the config monitor has applied, see
+// FeatureInstanceMonitor.Config, or an empty string if it can't be hashed.
+func activeConfig(monitor *FeatureInstanceMonitor, log ScopedLog) string {
+	hash, err := ConfigHash(monitor.Config())
+	if err != nil {
+		log.Warn("Could not hash active config.", map[string]interface{}{"error": err.Error()})
+		return ""
+	}
+	return hash
+}
+
+// watchActiveConfig re-registers the system whenever monitor applies a config
+// other than the one reported as the system's ActiveConfig. The API has no
+// endpoint for updating a system, so the system is deleted and created again
+// with the new ActiveConfig. The returned function stops watching, waiting
+// for a re-registration which is in progress.
+func (d *runningSystem) watchActiveConfig(monitor *FeatureInstanceMonitor) (stop func()) {
+	exited := make(chan struct{})
+	sub := monitor.subscribe(nil, func(change ConfigChange, done <-chan struct{}) {
+		hash := activeConfig(monitor, d.log)
+		if hash == "" || hash == to.String(d.inputs.ActiveConfig) {
+			return
+		}
+		d.reregister(hash, change.Version)
+	}, func() {
+		close(exited)
+	})
+	return func() {
+		monitor.removeSubscriber(sub)
+		<-exited
+	}
+}
+
+// reregister deletes the system and creates it again reporting hash as its
+// ActiveConfig. If the system can't be created it is retried on the next
+// change.
+func (d *runningSystem) reregister(hash string, version uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	log := d.log.With(map[string]interface{}{"activeConfig": hash, "version": version})
+	if !d.deleted {
+		if _, err := d.client.DeleteSystem(timeoutCtx(), to.String(d.system.Path)); err != nil {
+			log.Warn("Could not delete system to report its active config.", map[string]interface{}{"error": err.Error()})
+			return
+		}
+		d.deleted = true
+	}
+
+	inputs := *d.inputs
+	inputs.ActiveConfig = to.StringPtr(hash)
+	system, err := d.client.CreateSystem(timeoutCtx(), &inputs)
+	if err != nil {
+		log.Warn("Could not re-create system to report its active config.", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	d.system = &system
+	d.deleted = false
+	d.inputs = &inputs
+	log.Debug("Re-created system to report its active config.")
+}
+
+// ConfigDrift compares the config a system reports it is running
+// with the current config of its feature instance.
+type ConfigDrift struct {
+	SystemPath          string
+	FeatureInstancePath string
+	// ActiveConfig is the ActiveConfig reported by the system, if any.
+	ActiveConfig string
+	// ExpectedConfig is the ConfigHash of the feature instance's config, as
+	// it would be applied by the system's FeatureInstanceMonitor.
+	ExpectedConfig string
+	// Drifted is true if ActiveConfig is not ExpectedConfig, including
+	// when the system has not reported an ActiveConfig.
+	Drifted bool
+}
+
+// CheckConfigDrift gets the system at systemPath and its feature instance,
+// and reports whether the system is running the instance's current config.
+// Systems report the config their monitor applied, so the instance's config is
+// processed as it would be by a monitor created with options; pass the options
+// the system's monitor was created with. References are resolved in this
+// process's environment.
+func (client BaseClient) CheckConfigDrift(ctx context.Context, systemPath string, options MonitorOptions) (ConfigDrift, error) {
+	system, err := client.GetSystem(ctx, systemPath)
+	if err != nil {
+		return ConfigDrift{}, fmt.Errorf("error getting system %q: %s", systemPath, err)
+	}
+
+	drift := ConfigDrift{
+		SystemPath:          systemPath,
+		FeatureInstancePath: to.String(system.FeatureInstancePath),
+		ActiveConfig:        to.String(system.ActiveConfig),
+	}
+	if drift.FeatureInstancePath == "" {
+		return drift, fmt.Errorf("system %q does not implement a feature instance", systemPath)
+	}
+
+	nrn, err := ParseNRN(drift.FeatureInstancePath)
+	if err != nil {
+		return drift, err
+	}
+	featureInstance, err := client.GetFeatureInstance(ctx, nrn.Feature, nrn.Version, nrn.Instance)
+	if err != nil {
+		return drift, fmt.Errorf("error getting feature instance %q: %s", drift.FeatureInstancePath, err)
+	}
+
+	if options.Log == nil {
+		options.Log = EmptyLog{}
+	}
+	effective, err := processConfig(featureInstance, options, NewScopedLog(NewRedactingLog(options.Log), nrn))
+	if err != nil {
+		return drift, fmt.Errorf("error processing config of feature instance %q: %s", drift.FeatureInstancePath, err)
+	}
+	drift.ExpectedConfig, err = ConfigHash(effective.Config)
+	if err != nil {
+		return drift, err
+	}
+	drift.Drifted = drift.ActiveConfig != drift.ExpectedConfig
+	return drift, nil
+}
@@ -0,0 +1,130 @@
+package beacon_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("ActiveConfig", func() {
+
+	const instancePath = "nrn:beacon:naveego:fin:test-feature:1.0.0:test-instance::test-instance"
+
+	It("should hash configs regardless of key order", func() {
+		a, err := ConfigHash(map[string]interface{}{"a": 1, "b": []interface{}{"x"}})
+		Expect(err).ToNot(HaveOccurred())
+		b, err := ConfigHash(map[string]interface{}{"b": []string{"x"}, "a": 1.0})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a).To(HavePrefix("sha256:"))
+		Expect(a).To(Equal(b))
+
+		c, _ := ConfigHash(map[string]interface{}{"a": 2})
+		Expect(c).ToNot(Equal(a))
+	})
+
+	It("should report the active config of a system attached to a monitor", func() {
+		var (
+			mu       sync.Mutex
+			reported []string
+			deleted  int
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Method != http.MethodPost {
+				if r.Method == http.MethodDelete {
+					deleted++
+				}
+				fmt.Fprint(w, `"ok"`)
+				return
+			}
+			var inputs SystemInputs
+			Expect(json.NewDecoder(r.Body).Decode(&inputs)).To(Succeed())
+			reported = append(reported, to.String(inputs.ActiveConfig))
+			fmt.Fprintf(w, `{"path":"nrn:beacon:naveego:sys:test-feature:1.0.0:test-instance::%s"}`, to.String(inputs.Name))
+		}))
+		defer server.Close()
+		getReported := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), reported...)
+		}
+		getDeleted := func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return deleted
+		}
+
+		dir, err := ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "instance.json")
+		writeFeatureInstance(path, `{"config":{"n":1}}`)
+		monitor, err := NewFeatureInstanceMonitorWithOptions(NewFileSource(path), MonitorOptions{
+			FeatureConfig: map[string]interface{}{"m": 1},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		client := NewWithBaseURI(server.URL)
+		system := client.StartSystem(SystemOptions{
+			Name:                "test-system",
+			Tenant:              "naveego",
+			FeatureInstancePath: instancePath,
+			ConfigMonitor:       monitor,
+		}, new(recordingLog))
+
+		first, _ := ConfigHash(map[string]interface{}{"m": 1, "n": 1})
+		Expect(getReported()).To(Equal([]string{first}))
+
+		writeFeatureInstance(path, `{"config":{"n":2}}`)
+		monitor.Refresh()
+		second, _ := ConfigHash(map[string]interface{}{"m": 1, "n": 2})
+		Eventually(getReported).Should(Equal([]string{first, second}))
+		Expect(getDeleted()).To(Equal(1), "systems can't be updated, so the system is re-created")
+
+		system.Shutdown()
+		Expect(getDeleted()).To(Equal(2))
+		writeFeatureInstance(path, `{"config":{"n":3}}`)
+		monitor.Refresh()
+		Consistently(getReported).Should(HaveLen(2))
+	})
+
+	It("should detect drift between a system and its feature instance", func() {
+		hash, _ := ConfigHash(map[string]interface{}{"m": 1, "n": 1})
+		activeConfig := hash
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/features/instances/test-feature/1.0.0/test-instance":
+				fmt.Fprint(w, `{"config":{"n":1}}`)
+			default:
+				fmt.Fprintf(w, `{"featureInstancePath":%q,"activeConfig":%q}`, instancePath, activeConfig)
+			}
+		}))
+		defer server.Close()
+
+		client := NewWithBaseURI(server.URL)
+		options := MonitorOptions{FeatureConfig: map[string]interface{}{"m": 1}}
+		drift, err := client.CheckConfigDrift(context.Background(), "test-system", options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(drift.Drifted).To(BeFalse())
+		Expect(drift.ExpectedConfig).To(Equal(hash))
+
+		activeConfig = "sha256:old"
+		drift, err = client.CheckConfigDrift(context.Background(), "test-system", options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(drift.Drifted).To(BeTrue())
+		Expect(drift.ActiveConfig).To(Equal("sha256:old"))
+	})
+})
@@ -0,0 +1,82 @@
+package beacon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// loadCache replaces the FeatureInstance with the one saved at
+// options.CachePath and marks it as stale.
+func (s *FeatureInstanceMonitor) loadCache() error {
+	data, err := ioutil.ReadFile(s.options.CachePath)
+	if err != nil {
+		return err
+	}
+
+	// The cache holds the document as fetched, so its format is detected
+	// from its content rather than from CachePath.
+	featureInstance, err := DecodeFeatureInstance("", data)
+	if err != nil {
+		return fmt.Errorf("error deserializing cached config: %s", err)
+	}
+
+	effective, err := s.processConfig(*featureInstance)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rawFeatureInstance = data
+	s.featureInstance = featureInstance
+	s.effectiveConfig = effective
+	s.version++
+	s.stale = true
+	return nil
+}
+
+// saveCache saves data to options.CachePath, if it is set. Failures are
+// logged rather than returned, because they don't affect the monitor.
+func (s *FeatureInstanceMonitor) saveCache(data []byte) {
+	if s.options.CachePath == "" {
+		return
+	}
+	if err := writeFileAtomic(s.options.CachePath, data, 0600); err != nil {
+		s.logger().Error("error saving config to cache", err, map[string]interface{}{
+			"cache": s.options.CachePath,
+		})
+	}
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// path and renames it to path, so that readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
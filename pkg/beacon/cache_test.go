@@ -0,0 +1,97 @@
+package beacon_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("FeatureInstanceMonitor cache", func() {
+
+	var (
+		dir       string
+		path      string
+		cachePath string
+		log       *recordingLog
+		options   MonitorOptions
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "instance.json")
+		cachePath = filepath.Join(dir, "cache", "instance.json")
+		log = &recordingLog{}
+		options = MonitorOptions{CachePath: cachePath, Log: log}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should save each accepted config to the cache", func() {
+		writeFeatureInstance(path, `{"config":{"n":1}}`)
+		monitor, err := NewFeatureInstanceMonitorWithOptions(NewFileSource(path), options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(monitor.Stale()).To(BeFalse())
+		Expect(ioutil.ReadFile(cachePath)).To(MatchJSON(`{"config":{"n":1}}`))
+
+		writeFeatureInstance(path, `{"config":{"n":2}}`)
+		Expect(monitor.Refresh()).To(BeTrue())
+		Expect(ioutil.ReadFile(cachePath)).To(MatchJSON(`{"config":{"n":2}}`))
+	})
+
+	It("should fall back to the cache when the config cannot be retrieved", func() {
+		writeFeatureInstance(path, `{"config":{"n":1}}`)
+		_, err := NewFeatureInstanceMonitorWithOptions(NewFileSource(path), options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Remove(path)).To(Succeed())
+
+		monitor, err := NewFeatureInstanceMonitorWithOptions(NewFileSource(path), options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(monitor.Stale()).To(BeTrue())
+		Expect(monitor.FeatureInstance().Config).To(HaveKeyWithValue("n", float64(1)))
+		Expect(monitor.Version()).To(Equal(uint64(1)))
+
+		entries := log.Entries()
+		Expect(entries).ToNot(BeEmpty())
+		Expect(entries[len(entries)-1].level).To(Equal(LevelWarn))
+		Expect(entries[len(entries)-1].data[0]).To(HaveKeyWithValue("stale", true))
+
+		_, err = monitor.Refresh()
+		Expect(err).To(HaveOccurred())
+		Expect(monitor.Stale()).To(BeTrue())
+
+		writeFeatureInstance(path, `{"config":{"n":2}}`)
+		Expect(monitor.Refresh()).To(BeTrue())
+		Expect(monitor.Stale()).To(BeFalse())
+		Expect(monitor.FeatureInstance().Config).To(HaveKeyWithValue("n", float64(2)))
+	})
+
+	It("should stop being stale when the live config matches the cache", func() {
+		writeFeatureInstance(path, `{"config":{"n":1}}`)
+		_, err := NewFeatureInstanceMonitorWithOptions(NewFileSource(path), options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Rename(path, path+".bak")).To(Succeed())
+
+		monitor, err := NewFeatureInstanceMonitorWithOptions(NewFileSource(path), options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(monitor.Stale()).To(BeTrue())
+
+		Expect(os.Rename(path+".bak", path)).To(Succeed())
+		Expect(monitor.Refresh()).To(BeFalse())
+		Expect(monitor.Stale()).To(BeFalse())
+	})
+
+	It("should fail if there is no usable cache", func() {
+		_, err := NewFeatureInstanceMonitorWithOptions(NewFileSource(path), options)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("cached config could not be used"))
+	})
+})
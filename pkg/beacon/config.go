@@ -0,0 +1,328 @@
+package beacon
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// ConfigValidator is implemented by config types which can check
+// themselves after being bound by BindConfig.
+type ConfigValidator interface {
+	Validate() error
+}
+
+// BindOptions control how a config is bound to a struct.
+type BindOptions struct {
+	// ErrorUnused causes binding to fail if the config contains keys
+	// which do not correspond to a field of the target.
+	ErrorUnused bool
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+	urlType      = reflect.TypeOf(url.URL{})
+)
+
+// BindConfig decodes config into target, which must be a pointer to a struct.
+//
+// Fields are named as in GenerateJSONSchema: by their json tag, then their
+// mapstructure tag, then the field name, and the fields of embedded structs
+// of exported types are flattened into their parent. Fields tagged with
+// `default:"value"` are set to value unless they are present in config;
+// slice defaults are comma-separated. Strings are decoded into
+// time.Duration, time.Time (RFC3339) and url.URL fields. If the bound target
+// implements ConfigValidator, its Validate method is called and its error
+// returned.
+func BindConfig(config interface{}, target interface{}, options BindOptions) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config target must be a non-nil pointer to a struct, got %T", target)
+	}
+
+	if err := applyDefaults(v.Elem(), "", make(map[reflect.Type]bool)); err != nil {
+		return err
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			configFieldNamesHookFunc,
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToTimeHookFunc(time.RFC3339),
+			stringToURLHookFunc,
+		),
+		ErrorUnused: options.ErrorUnused,
+		TagName:     "json",
+		Result:      target,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = decoder.Decode(config); err != nil {
+		return err
+	}
+
+	if validator, ok := target.(ConfigValidator); ok {
+		if err = validator.Validate(); err != nil {
+			return fmt.Errorf("invalid config: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// configFieldNamesHookFunc renames the keys of an object decoded into a
+// struct to the names mapstructure looks its fields up by, given that it
+// only reads json tags and doesn't flatten embedded structs: keys naming
+// fields by their mapstructure tag are renamed to the field name, and keys
+// naming fields of embedded structs are moved into an object named after
+// the embedded struct.
+func configFieldNamesHookFunc(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+	m, ok := data.(map[string]interface{})
+	if !ok || t.Kind() != reflect.Struct || t == timeType || t == urlType {
+		return data, nil
+	}
+
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, skip := schemaFieldName(field)
+		if skip {
+			continue
+		}
+		if embedded := embeddedStruct(field, name); embedded != nil {
+			nested := map[string]interface{}{}
+			for _, key := range configFieldNames(embedded) {
+				if v, ok := out[key]; ok {
+					nested[key] = v
+					delete(out, key)
+				}
+			}
+			if len(nested) > 0 {
+				out[field.Name] = nested
+			}
+			continue
+		}
+		if jsonName := strings.Split(field.Tag.Get("json"), ",")[0]; jsonName != "" || name == "" {
+			continue
+		}
+		if v, ok := out[name]; ok {
+			delete(out, name)
+			out[field.Name] = v
+		}
+	}
+	return out, nil
+}
+
+// embeddedStruct returns the type of field if it is an untagged embedded
+// struct, or nil.
+func embeddedStruct(field reflect.StructField, name string) reflect.Type {
+	if !field.Anonymous || name != "" {
+		return nil
+	}
+	t := field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+// configFieldNames returns the names of the fields of t in a config,
+// including those of embedded structs.
+func configFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, skip := schemaFieldName(field)
+		if skip {
+			continue
+		}
+		if embedded := embeddedStruct(field, name); embedded != nil {
+			names = append(names, configFieldNames(embedded)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func stringToURLHookFunc(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+	if f.Kind() != reflect.String || t != urlType {
+		return data, nil
+	}
+	u, err := url.Parse(data.(string))
+	if err != nil {
+		return nil, err
+	}
+	return *u, nil
+}
+
+// applyDefaults sets the fields of v which have a default tag to their default
+// values, recursing into nested structs. Nil pointers to structs with
+// defaults are allocated, except where a type would contain itself; visiting
+// contains the struct types being defaulted.
+func applyDefaults(v reflect.Value, path string, visiting map[reflect.Type]bool) error {
+	t := v.Type()
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fieldPath := field.Name
+		if path != "" {
+			fieldPath = path + "." + field.Name
+		}
+		fv := v.Field(i)
+
+		if def, ok := field.Tag.Lookup("default"); ok {
+			if err := setDefault(fv, def); err != nil {
+				return fmt.Errorf("invalid default for %s: %s", fieldPath, err)
+			}
+			continue
+		}
+
+		if fv.Kind() == reflect.Ptr {
+			elem := fv.Type().Elem()
+			if !defaultsStruct(elem) || visiting[elem] || !hasDefaults(elem, make(map[reflect.Type]bool)) {
+				continue
+			}
+			if fv.IsNil() {
+				fv.Set(reflect.New(elem))
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct && defaultsStruct(fv.Type()) {
+			if err := applyDefaults(fv, fieldPath, visiting); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// defaultsStruct returns true if t is a struct whose fields can have defaults.
+func defaultsStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && t != urlType
+}
+
+// hasDefaults returns true if a field of t, or of a struct in it, has a
+// default tag.
+func hasDefaults(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if _, ok := field.Tag.Lookup("default"); ok {
+			return true
+		}
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if defaultsStruct(ft) && hasDefaults(ft, visiting) {
+			return true
+		}
+	}
+	return false
+}
+
+func setDefault(v reflect.Value, def string) error {
+	switch v.Type() {
+	case durationType:
+		d, err := time.ParseDuration(def)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case timeType:
+		t, err := time.Parse(time.RFC3339, def)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case urlType:
+		u, err := url.Parse(def)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(def)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(def)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(def, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(def, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(def, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if err := setDefault(elem.Elem(), def); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Slice:
+		if def == "" {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			return nil
+		}
+		parts := strings.Split(def, ",")
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setDefault(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("defaults are not supported for %s fields", v.Type())
+	}
+	return nil
+}
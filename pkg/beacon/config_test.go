@@ -0,0 +1,186 @@
+package beacon_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+type testDBConfig struct {
+	URL     url.URL
+	Timeout time.Duration `default:"5s"`
+	Pool    int           `default:"10"`
+}
+
+type testConfig struct {
+	Name      string   `default:"unnamed"`
+	Tags      []string `default:"a,b"`
+	StartedAt time.Time
+	DB        testDBConfig
+}
+
+func (t testConfig) Validate() error {
+	if t.Name == "invalid" {
+		return errors.New("name is invalid")
+	}
+	return nil
+}
+
+type testPointerConfig struct {
+	DB   *testDBConfig
+	Next *testPointerConfig
+}
+
+type TestCommonConfig struct {
+	Region string `mapstructure:"region_name"`
+}
+
+type testTaggedConfig struct {
+	TestCommonConfig
+	DBURL   string        `json:"db_url"`
+	Retries int           `json:"retries,omitempty" mapstructure:"max_retries"`
+	Timeout time.Duration `mapstructure:"timeout_after" default:"5s"`
+	Ignored string        `json:"-"`
+}
+
+func writeFeatureInstance(path string, json string) {
+	Expect(ioutil.WriteFile(path, []byte(json), 0600)).To(Succeed())
+}
+
+var _ = Describe("Config", func() {
+
+	Describe("BindConfig", func() {
+
+		It("should apply defaults and decode special types", func() {
+			var sut testConfig
+			Expect(BindConfig(map[string]interface{}{
+				"startedAt": "2018-06-01T12:00:00Z",
+				"db": map[string]interface{}{
+					"url":     "postgres://localhost/db",
+					"timeout": "1m",
+				},
+			}, &sut, BindOptions{})).To(Succeed())
+
+			Expect(sut.Name).To(Equal("unnamed"))
+			Expect(sut.Tags).To(Equal([]string{"a", "b"}))
+			Expect(sut.StartedAt).To(Equal(time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)))
+			Expect(sut.DB.URL.Host).To(Equal("localhost"))
+			Expect(sut.DB.Timeout).To(Equal(time.Minute))
+			Expect(sut.DB.Pool).To(Equal(10))
+		})
+
+		It("should apply defaults through pointers to structs", func() {
+			var sut testPointerConfig
+			Expect(BindConfig(map[string]interface{}{"db": map[string]interface{}{"pool": 3}}, &sut, BindOptions{})).To(Succeed())
+			Expect(sut.DB.Timeout).To(Equal(5 * time.Second))
+			Expect(sut.DB.Pool).To(Equal(3))
+			Expect(sut.Next).To(BeNil())
+
+			sut = testPointerConfig{}
+			Expect(BindConfig(map[string]interface{}{}, &sut, BindOptions{})).To(Succeed())
+			Expect(sut.DB).To(Equal(&testDBConfig{Timeout: 5 * time.Second, Pool: 10}))
+		})
+
+		It("should not be used by ExtractConfig", func() {
+			var sut testTaggedConfig
+			fi := FeatureInstance{Config: map[string]interface{}{"dburl": "postgres://localhost/db", "db_url": "ignored"}}
+			Expect(fi.ExtractConfig(&sut)).To(Succeed())
+			Expect(sut.DBURL).To(Equal("postgres://localhost/db"))
+			Expect(sut.Timeout).To(BeZero())
+		})
+
+		It("should error on unused keys if requested", func() {
+			var sut testConfig
+			config := map[string]interface{}{"nmae": "typo"}
+			Expect(BindConfig(config, &sut, BindOptions{})).To(Succeed())
+			Expect(BindConfig(config, &sut, BindOptions{ErrorUnused: true})).To(MatchError(ContainSubstring("nmae")))
+		})
+
+		It("should name fields as in the generated schema", func() {
+			config := map[string]interface{}{
+				"db_url":        "postgres://localhost/db",
+				"retries":       3,
+				"timeout_after": "1m",
+				"region_name":   "us-east-1",
+			}
+			schema, err := GenerateJSONSchema(testTaggedConfig{})
+			Expect(err).ToNot(HaveOccurred())
+			compiled, err := CompileJSONSchema(schema)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(compiled.Validate(config)).To(Succeed())
+
+			var sut testTaggedConfig
+			Expect(BindConfig(config, &sut, BindOptions{ErrorUnused: true})).To(Succeed())
+			Expect(sut).To(Equal(testTaggedConfig{
+				TestCommonConfig: TestCommonConfig{Region: "us-east-1"},
+				DBURL:            "postgres://localhost/db",
+				Retries:          3,
+				Timeout:          time.Minute,
+			}))
+		})
+
+		It("should validate", func() {
+			var sut testConfig
+			Expect(BindConfig(map[string]interface{}{"name": "invalid"}, &sut, BindOptions{})).
+				To(MatchError(ContainSubstring("name is invalid")))
+		})
+
+		It("should reject non-struct targets", func() {
+			var sut map[string]interface{}
+			Expect(BindConfig(map[string]interface{}{}, &sut, BindOptions{})).To(HaveOccurred())
+		})
+	})
+
+	Describe("FeatureInstanceMonitor.Bind", func() {
+
+		var (
+			dir  string
+			path string
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "beacon-test")
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(dir, "instance.json")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should rebind on change and reject invalid configs", func() {
+			writeFeatureInstance(path, `{"instanceName":"a","config":{"name":"first"}}`)
+			sut, err := NewFeatureInstanceMonitorFromURL(path)
+			Expect(err).ToNot(HaveOccurred())
+
+			var config testConfig
+			binding, err := sut.Bind(&config, BindOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Name).To(Equal("first"))
+
+			writeFeatureInstance(path, `{"instanceName":"a","config":{"name":"second"}}`)
+			Expect(sut.Refresh()).To(BeTrue())
+			Expect(config.Name).To(Equal("first"))
+			Expect(binding.Value()).To(Equal(&testConfig{Name: "second", Tags: []string{"a", "b"}, DB: testDBConfig{Timeout: 5 * time.Second, Pool: 10}}))
+			Expect(binding.Load(&config)).To(Succeed())
+			Expect(config.Name).To(Equal("second"))
+
+			writeFeatureInstance(path, `{"instanceName":"a","config":{"name":"invalid"}}`)
+			_, err = sut.Refresh()
+			Expect(err).To(MatchError(ContainSubstring("name is invalid")))
+			Expect(binding.Load(&config)).To(Succeed())
+			Expect(config.Name).To(Equal("second"))
+			Expect(binding.Load(&testDBConfig{})).To(MatchError(ContainSubstring("must be a non-nil *beacon_test.testConfig")))
+			Expect(sut.FeatureInstance().Config).To(HaveKeyWithValue("name", "second"))
+		})
+	})
+})
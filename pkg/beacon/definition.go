@@ -0,0 +1,207 @@
+package beacon
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// FeatureDefinition builds the definition of the feature a service
+// implements, so that the service owns it and can register it with
+// EnsureFeature when it starts. Errors are reported by Feature.
+type FeatureDefinition struct {
+	feature Feature
+	errs    []string
+}
+
+// NewFeatureDefinition returns a definition of the feature named name. Its
+// version is the version of the main module in the build info, without the
+// leading "v", unless it is set with Version.
+func NewFeatureDefinition(name string) *FeatureDefinition {
+	d := &FeatureDefinition{feature: Feature{Name: to.StringPtr(name)}}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		d.feature.Version = to.StringPtr(strings.TrimPrefix(info.Main.Version, "v"))
+	}
+	return d
+}
+
+func (d *FeatureDefinition) fail(format string, args ...interface{}) *FeatureDefinition {
+	d.errs = append(d.errs, fmt.Sprintf(format, args...))
+	return d
+}
+
+// Version sets the version of the feature, which must be a SemVer version.
+func (d *FeatureDefinition) Version(version string) *FeatureDefinition {
+	d.feature.Version = to.StringPtr(strings.TrimPrefix(version, "v"))
+	return d
+}
+
+// Label sets a label on the feature.
+func (d *FeatureDefinition) Label(key string, value interface{}) *FeatureDefinition {
+	labels, _ := d.feature.Labels.(map[string]interface{})
+	if labels == nil {
+		labels = map[string]interface{}{}
+		d.feature.Labels = labels
+	}
+	labels[key] = value
+	return d
+}
+
+// PerTenant sets whether instances of the feature belong to tenants.
+func (d *FeatureDefinition) PerTenant(isPerTenant bool) *FeatureDefinition {
+	d.feature.IsPerTenant = to.BoolPtr(isPerTenant)
+	return d
+}
+
+// Healthcheck adds a healthcheck of type t run every interval.
+func (d *FeatureDefinition) Healthcheck(name string, t Type1, interval time.Duration) *FeatureDefinition {
+	var healthchecks []Healthcheck
+	if d.feature.Healthchecks != nil {
+		healthchecks = *d.feature.Healthchecks
+	}
+	healthchecks = append(healthchecks, Healthcheck{
+		Name:       to.StringPtr(name),
+		Type:       t,
+		IntervalMS: to.Float64Ptr(float64(interval / time.Millisecond)),
+	})
+	d.feature.Healthchecks = &healthchecks
+	return d
+}
+
+// Config sets the instance config schema of the feature to the schema
+// generated from the config struct the service binds its config to.
+func (d *FeatureDefinition) Config(config interface{}) *FeatureDefinition {
+	if err := d.feature.SetConfigSchemaFrom(config); err != nil {
+		return d.fail("error generating config schema: %s", err)
+	}
+	return d
+}
+
+// ProvisioningTask adds a task run when an instance is provisioned. The
+// layout may be nil.
+func (d *FeatureDefinition) ProvisioningTask(name string, values interface{}, layout *Layout) *FeatureDefinition {
+	d.feature.ProvisioningTasks = appendTaskSpec(d.feature.ProvisioningTasks, name, values, layout)
+	return d
+}
+
+// UnprovisioningTask adds a task run when an instance is unprovisioned. The
+// layout may be nil.
+func (d *FeatureDefinition) UnprovisioningTask(name string, values interface{}, layout *Layout) *FeatureDefinition {
+	d.feature.UnprovisioningTasks = appendTaskSpec(d.feature.UnprovisioningTasks, name, values, layout)
+	return d
+}
+
+func appendTaskSpec(tasks *[]TaskSpec, name string, values interface{}, layout *Layout) *[]TaskSpec {
+	var out []TaskSpec
+	if tasks != nil {
+		out = *tasks
+	}
+	out = append(out, TaskSpec{TaskName: to.StringPtr(name), Values: values, Layout: layout})
+	return &out
+}
+
+// ProvisioningTimeout sets the time allowed for (un)provisioning.
+func (d *FeatureDefinition) ProvisioningTimeout(timeout time.Duration) *FeatureDefinition {
+	d.feature.ProvisioningTimeoutMS = to.Float64Ptr(float64(timeout / time.Millisecond))
+	return d
+}
+
+// SystemStartup sets the time to wait for implementing systems to come
+// online after an instance is enabled, and the number of times to retry.
+func (d *FeatureDefinition) SystemStartup(timeout time.Duration, retries int) *FeatureDefinition {
+	d.feature.SystemStartupMS = to.Float64Ptr(float64(timeout / time.Millisecond))
+	d.feature.SystemStartupRetries = to.Float64Ptr(float64(retries))
+	return d
+}
+
+// Feature returns the defined feature, or an error if the definition is
+// invalid.
+func (d *FeatureDefinition) Feature() (Feature, error) {
+	errs := append([]string(nil), d.errs...)
+	if to.String(d.feature.Name) == "" {
+		errs = append(errs, "name is required")
+	}
+	if d.feature.Version == nil {
+		errs = append(errs, "version is required, as the build info has no module version")
+	} else if _, err := ParseSemVer(*d.feature.Version); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return Feature{}, fmt.Errorf("invalid definition of feature %s: %s", to.String(d.feature.Name), strings.Join(errs, "; "))
+	}
+	return d.feature, nil
+}
+
+// FeatureDefinitionChangedError is returned by EnsureFeature if a version of
+// a feature is already published with a different definition.
+type FeatureDefinitionChangedError struct {
+	Name    string
+	Version string
+	// Paths are the fields of the definition which differ.
+	Paths []string
+}
+
+func (e *FeatureDefinitionChangedError) Error() string {
+	return fmt.Sprintf("feature %s@%s is already published with a different definition (%s); publish a new version instead",
+		e.Name, e.Version, strings.Join(e.Paths, ", "))
+}
+
+// EnsureFeature creates the feature defined by def unless its version is
+// already published, and returns the feature as published. Published
+// versions can't be changed, so if the published definition differs in any
+// of the fields def sets it returns a *FeatureDefinitionChangedError. Fields
+// and labels which def doesn't set are ignored, as are fields added to the
+// elements of lists, such as healthchecks, so long as the lists have the same
+// length. If creating the feature fails because another replica of the
+// service published it first, the published feature is compared instead.
+// See Feature.InstanceNRN to start a system implementing an instance of it.
+func EnsureFeature(ctx context.Context, client BaseClient, def *FeatureDefinition) (Feature, error) {
+	f, err := def.Feature()
+	if err != nil {
+		return Feature{}, err
+	}
+	name, version := to.String(f.Name), to.String(f.Version)
+
+	published, ok, err := publishedFeature(ctx, client, f)
+	if err != nil || ok {
+		return published, err
+	}
+
+	created, err := client.CreateFeature(ctx, &f)
+	if err != nil {
+		// Another replica may have published it since it was looked up.
+		if published, ok, changedErr := publishedFeature(ctx, client, f); ok {
+			return published, changedErr
+		}
+		return Feature{}, fmt.Errorf("error creating feature %s@%s: %s", name, version, err)
+	}
+	return created, nil
+}
+
+// publishedFeature returns the published version of f, and whether it is
+// published. It returns a *FeatureDefinitionChangedError if the published
+// feature differs from f.
+func publishedFeature(ctx context.Context, client BaseClient, f Feature) (Feature, bool, error) {
+	name, version := to.String(f.Name), to.String(f.Version)
+	features, err := client.GetFeatures(ctx, name, version)
+	if err != nil {
+		return Feature{}, false, fmt.Errorf("error getting feature %s@%s: %s", name, version, err)
+	}
+	if features.Value == nil {
+		return Feature{}, false, nil
+	}
+	for _, current := range *features.Value {
+		if to.String(current.Name) != name || to.String(current.Version) != version {
+			continue
+		}
+		if paths := manifestDiff(current, f); len(paths) > 0 {
+			return Feature{}, true, &FeatureDefinitionChangedError{Name: name, Version: version, Paths: paths}
+		}
+		return current, true, nil
+	}
+	return Feature{}, false, nil
+}
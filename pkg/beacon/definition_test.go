@@ -0,0 +1,168 @@
+package beacon_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+type definitionConfig struct {
+	Region string `json:"region" default:"us-east-1"`
+	Port   int    `json:"port"`
+}
+
+var _ = Describe("FeatureDefinition", func() {
+
+	var (
+		mu       sync.Mutex
+		features []map[string]interface{}
+		created  int
+		// raced is published by another replica when a feature is created.
+		raced  map[string]interface{}
+		server *httptest.Server
+		client BaseClient
+	)
+
+	BeforeEach(func() {
+		features = nil
+		created = 0
+		raced = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/api/features":
+				json.NewEncoder(w).Encode(features)
+			case r.Method == http.MethodPost && r.URL.Path == "/api/features" && raced != nil:
+				features = append(features, raced)
+				w.WriteHeader(http.StatusConflict)
+			case r.Method == http.MethodPost && r.URL.Path == "/api/features":
+				var body map[string]interface{}
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				body["path"] = FeatureNRN("", body["name"].(string), body["version"].(string)).String()
+				features = append(features, body)
+				created++
+				json.NewEncoder(w).Encode(body)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		client = NewWithBaseURI(server.URL)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	definition := func() *FeatureDefinition {
+		return NewFeatureDefinition("orders").
+			Version("v1.2.0").
+			Healthcheck("heartbeat", Type1Heartbeat, 30*time.Second).
+			Config(definitionConfig{}).
+			ProvisioningTask("create-database", map[string]interface{}{"name": "orders"}, nil).
+			SystemStartup(time.Minute, 3)
+	}
+
+	It("should build the feature", func() {
+		f, err := definition().Feature()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(to.String(f.Version)).To(Equal("1.2.0"))
+		Expect(*f.Healthchecks).To(Equal([]Healthcheck{{Name: to.StringPtr("heartbeat"), Type: Type1Heartbeat, IntervalMS: to.Float64Ptr(30000)}}))
+		Expect(f.InstanceConfigSchema).To(HaveKey("properties"))
+		Expect(*f.ProvisioningTasks).To(HaveLen(1))
+		Expect(to.Float64(f.SystemStartupMS)).To(Equal(60000.0))
+		Expect(f.InstanceNRN("acme", "east").String()).To(Equal("nrn:beacon:acme:fin:orders:1.2.0:east::east"))
+	})
+
+	It("should report invalid definitions", func() {
+		_, err := NewFeatureDefinition("orders").Version("1.2").Config(42).Feature()
+		Expect(err).To(MatchError(ContainSubstring("invalid definition of feature orders: error generating config schema")))
+		Expect(err).To(MatchError(ContainSubstring(`invalid version "1.2"`)))
+
+		// Test binaries have no module version in their build info.
+		_, err = NewFeatureDefinition("orders").Feature()
+		Expect(err).To(MatchError(ContainSubstring("version is required")))
+	})
+
+	It("should create the feature once", func() {
+		f, err := EnsureFeature(context.Background(), client, definition())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(to.String(f.Path)).To(Equal("nrn:beacon::ftr:orders:1.2.0:::orders"))
+
+		again, err := EnsureFeature(context.Background(), client, definition())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(again.Path).To(Equal(f.Path))
+		Expect(created).To(Equal(1))
+	})
+
+	It("should fail if a published version's definition changed", func() {
+		_, err := EnsureFeature(context.Background(), client, definition())
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = EnsureFeature(context.Background(), client, definition().Healthcheck("ping", Type1HTTP, time.Second))
+		Expect(err).To(BeAssignableToTypeOf(&FeatureDefinitionChangedError{}))
+		Expect(err.(*FeatureDefinitionChangedError).Paths).To(Equal([]string{"healthchecks"}))
+		Expect(err).To(MatchError("feature orders@1.2.0 is already published with a different definition (healthchecks); publish a new version instead"))
+		Expect(created).To(Equal(1))
+
+		_, err = EnsureFeature(context.Background(), client, definition().Version("1.3.0").Healthcheck("ping", Type1HTTP, time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(created).To(Equal(2))
+	})
+
+	It("should ignore fields and labels added on the server", func() {
+		f, err := definition().Label("team", "orders").Feature()
+		Expect(err).ToNot(HaveOccurred())
+		published, err := normalizeFeature(f)
+		Expect(err).ToNot(HaveOccurred())
+		published["labels"].(map[string]interface{})["owner"] = "ops"
+		published["healthchecks"].([]interface{})[0].(map[string]interface{})["id"] = "h1"
+		features = append(features, published)
+
+		_, err = EnsureFeature(context.Background(), client, definition().Label("team", "orders"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = EnsureFeature(context.Background(), client, definition().Label("team", "billing"))
+		Expect(err).To(MatchError(ContainSubstring("(labels.team)")))
+		Expect(created).To(Equal(0))
+	})
+
+	It("should use the feature published by another replica while creating it", func() {
+		f, err := definition().Feature()
+		Expect(err).ToNot(HaveOccurred())
+		raced, err = normalizeFeature(f)
+		Expect(err).ToNot(HaveOccurred())
+		raced["path"] = "nrn:beacon::ftr:orders:1.2.0:::orders"
+
+		published, err := EnsureFeature(context.Background(), client, definition())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(to.String(published.Path)).To(Equal("nrn:beacon::ftr:orders:1.2.0:::orders"))
+
+		features = nil
+		_, err = EnsureFeature(context.Background(), client, definition().SystemStartup(time.Second, 1))
+		Expect(err).To(BeAssignableToTypeOf(&FeatureDefinitionChangedError{}))
+
+		features = nil
+		raced = map[string]interface{}{"name": "other", "version": "1.0.0"}
+		_, err = EnsureFeature(context.Background(), client, definition())
+		Expect(err).To(MatchError(HavePrefix("error creating feature orders@1.2.0: ")))
+	})
+})
+
+// normalizeFeature returns f as the server would return it.
+func normalizeFeature(f Feature) (map[string]interface{}, error) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	return out, json.Unmarshal(b, &out)
+}
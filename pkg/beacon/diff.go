@@ -0,0 +1,97 @@
+package beacon
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigChange describes a change to a monitored FeatureInstance.
+type ConfigChange struct {
+	Old FeatureInstance
+	New FeatureInstance
+	// Version is the version of New, see FeatureInstanceMonitor.Version.
+	Version uint64
+	// Paths are the dot-delimited JSON paths of the values which changed,
+	// relative to the FeatureInstance, like "isEnabled", "labels.env" or
+	// "config.db.url". Arrays are compared as a whole.
+	Paths []string
+
+	oldDoc interface{}
+	newDoc interface{}
+}
+
+// NewConfigChange returns the ConfigChange from old to new.
+func NewConfigChange(old, new FeatureInstance) ConfigChange {
+	c := ConfigChange{Old: old, New: new}
+	c.oldDoc, _ = normalizeJSON(old, false)
+	c.newDoc, _ = normalizeJSON(new, false)
+	c.Paths = diffJSON(c.oldDoc, c.newDoc, "", nil)
+	sort.Strings(c.Paths)
+	return c
+}
+
+// Changed returns true if the value at path, or anything beneath it, changed.
+// The path is relative to the FeatureInstance, like Paths.
+func (c ConfigChange) Changed(path string) bool {
+	return !reflect.DeepEqual(lookupJSONPath(c.oldDoc, path), lookupJSONPath(c.newDoc, path))
+}
+
+// ConfigChanged returns true if the value at path within the Config, or
+// anything beneath it, changed. An empty path checks the whole Config.
+func (c ConfigChange) ConfigChanged(path string) bool {
+	if path == "" {
+		return c.Changed("config")
+	}
+	return c.Changed("config." + path)
+}
+
+// diffJSON appends to paths the paths at which a and b, which are
+// values produced by json.Unmarshal, differ.
+func diffJSON(a, b interface{}, path string, paths []string) []string {
+	am, aIsMap := a.(map[string]interface{})
+	bm, bIsMap := b.(map[string]interface{})
+	if !aIsMap || !bIsMap {
+		if !reflect.DeepEqual(a, b) {
+			paths = append(paths, path)
+		}
+		return paths
+	}
+
+	for k, av := range am {
+		bv, ok := bm[k]
+		if !ok {
+			bv = nil
+		}
+		paths = diffJSON(av, bv, joinJSONPath(path, k), paths)
+	}
+	for k, bv := range bm {
+		if _, ok := am[k]; !ok {
+			paths = diffJSON(nil, bv, joinJSONPath(path, k), paths)
+		}
+	}
+	return paths
+}
+
+func joinJSONPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// lookupJSONPath returns the value at the dot-delimited path in doc,
+// or nil if there is no such value.
+func lookupJSONPath(doc interface{}, path string) interface{} {
+	if path == "" {
+		return doc
+	}
+	for _, key := range strings.Split(path, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = m[key]
+	}
+	return doc
+}
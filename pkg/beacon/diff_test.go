@@ -0,0 +1,76 @@
+package beacon_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("ConfigChange", func() {
+
+	old := FeatureInstance{
+		IsEnabled: to.BoolPtr(true),
+		Labels:    map[string]interface{}{"env": "dev"},
+		Config: map[string]interface{}{
+			"db":    map[string]interface{}{"url": "a", "timeout": 5},
+			"hosts": []interface{}{"x"},
+		},
+	}
+
+	It("should list changed paths", func() {
+		new := FeatureInstance{
+			IsEnabled: to.BoolPtr(false),
+			Labels:    map[string]interface{}{"env": "dev", "team": "core"},
+			Config: map[string]interface{}{
+				"db":    map[string]interface{}{"url": "b", "timeout": 5},
+				"hosts": []interface{}{"x", "y"},
+			},
+		}
+		sut := NewConfigChange(old, new)
+		Expect(sut.Paths).To(Equal([]string{"config.db.url", "config.hosts", "isEnabled", "labels.team"}))
+		Expect(sut.ConfigChanged("db")).To(BeTrue())
+		Expect(sut.ConfigChanged("db.url")).To(BeTrue())
+		Expect(sut.ConfigChanged("db.timeout")).To(BeFalse())
+		Expect(sut.Changed("labels.env")).To(BeFalse())
+	})
+
+	It("should treat removed subtrees as changed", func() {
+		sut := NewConfigChange(old, FeatureInstance{IsEnabled: to.BoolPtr(true), Labels: old.Labels})
+		Expect(sut.Paths).To(Equal([]string{"config"}))
+		Expect(sut.ConfigChanged("db.url")).To(BeTrue())
+	})
+
+	It("should invoke path watchers only when the path changes", func() {
+		dir, err := ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "instance.json")
+		writeFeatureInstance(path, `{"config":{"db":{"url":"a","timeout":1}}}`)
+
+		monitor, err := NewFeatureInstanceMonitorFromURL(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		changes := make(chan ConfigChange, 10)
+		stop := monitor.WatchPath("db.url", func(c ConfigChange) { changes <- c })
+		defer stop()
+		Eventually(changes).Should(Receive())
+
+		writeFeatureInstance(path, `{"config":{"db":{"url":"a","timeout":2}}}`)
+		Expect(monitor.Refresh()).To(BeTrue())
+		Consistently(changes, 20*time.Millisecond).ShouldNot(Receive())
+
+		writeFeatureInstance(path, `{"config":{"db":{"url":"b","timeout":2}}}`)
+		Expect(monitor.Refresh()).To(BeTrue())
+		var change ConfigChange
+		Eventually(changes).Should(Receive(&change))
+		Expect(change.Paths).To(Equal([]string{"config.db.url"}))
+		Expect(change.Old.Config).To(HaveKeyWithValue("db", HaveKeyWithValue("url", "a")))
+	})
+})
@@ -6,44 +6,44 @@ import (
 
 type dummySystem struct {
 	nrn NRN
-	log Log
+	log ScopedLog
 }
 type dummyExpectation struct {
 	nrn NRN
-	log Log
+	log ScopedLog
 }
 
 func (d *dummySystem) Child(options SystemOptions) RunningSystem {
-	d.log.Debug(d.nrn, "Creating child system.", map[string]interface{}{"options": options})
+	d.log.Debug("Creating child system.", map[string]interface{}{"options": options})
 	nrn := d.nrn.ChildSystem(options.Name)
 	return &dummySystem{
 		nrn: nrn,
-		log: d.log,
+		log: d.log.Scope(nrn).With(map[string]interface{}{"options": options}),
 	}
 }
 func (d *dummySystem) Expectation(options ExpectationOptions) RunningExpectation {
-	d.log.Debug(d.nrn, "Creating child expectation.", map[string]interface{}{"options": options})
+	d.log.Debug("Creating child expectation.", map[string]interface{}{"options": options})
 	nrn := d.nrn.ChildExpectation(options.Name)
 	return &dummyExpectation{
 		nrn: nrn,
-		log: d.log,
+		log: d.log.Scope(nrn).With(map[string]interface{}{"options": options}),
 	}
 }
 
 func (d *dummySystem) Shutdown() {
-	d.log.Debug(d.nrn, "Shutdown.")
+	d.log.Debug("Shutdown.")
 }
 func (d *dummyExpectation) Fulfil(message string) {
-	d.log.Debug(d.nrn, "Fulfilled")
+	d.log.Debug("Fulfilled")
 }
 func (d *dummyExpectation) Fail(message string) {
-	d.log.Debug(d.nrn, "Failed")
+	d.log.Debug("Failed")
 }
 func (d *dummyExpectation) Retire() {
-	d.log.Debug(d.nrn, "Retired")
+	d.log.Debug("Retired")
 }
 
 func (d *dummyExpectation) Reschedule(message string, rescheduleTo time.Time) {
-	d.log.Debug(d.nrn, "Rescheduled.", map[string]interface{}{"message": message, "rescheduleTo": rescheduleTo})
+	d.log.Debug("Rescheduled.", map[string]interface{}{"message": message, "rescheduleTo": rescheduleTo})
 
 }
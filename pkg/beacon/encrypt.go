@@ -0,0 +1,443 @@
+package beacon
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// EncryptedValueKey is the key of an encrypted config value's envelope,
+	// like {"$enc": "aesgcm:v1:<key id>:<ciphertext>"}.
+	EncryptedValueKey = "$enc"
+	// encryptedValuePrefix is the algorithm and version of encrypted values.
+	encryptedValuePrefix = "aesgcm:v1:"
+	// keyringKeySize is the size of keys created by Keyring.GenerateKey, for AES-256.
+	keyringKeySize = 32
+)
+
+// Keyring holds the keys used to encrypt and decrypt config values.
+// Values are encrypted with the primary key, and may be decrypted with any
+// key in the keyring, so that keys can be rotated.
+type Keyring struct {
+	mu      sync.RWMutex
+	primary string
+	keys    map[string][]byte
+}
+
+// keyringFile is the JSON form of a Keyring. Keys are base64 encoded.
+type keyringFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewKeyring returns an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+// LoadKeyring loads a Keyring from a JSON file like
+//
+//	{"primary": "k2", "keys": {"k1": "<base64 key>", "k2": "<base64 key>"}}
+//
+// Keys must be 16, 24 or 32 bytes, for AES-128, AES-192 or AES-256.
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keyring: %s", err)
+	}
+	var file keyringFile
+	if err = json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("error deserializing keyring %q: %s", path, err)
+	}
+
+	k := NewKeyring()
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("keyring %q: key %q is not base64: %s", path, id, err)
+		}
+		if err = k.AddKey(id, key); err != nil {
+			return nil, fmt.Errorf("keyring %q: %s", path, err)
+		}
+	}
+	if file.Primary != "" {
+		if err = k.SetPrimary(file.Primary); err != nil {
+			return nil, fmt.Errorf("keyring %q: %s", path, err)
+		}
+	}
+	return k, nil
+}
+
+// Save writes the keyring to path atomically, readable only by its owner.
+func (k *Keyring) Save(path string) error {
+	k.mu.RLock()
+	file := keyringFile{Primary: k.primary, Keys: make(map[string]string, len(k.keys))}
+	for id, key := range k.keys {
+		file.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	k.mu.RUnlock()
+
+	b, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b, 0600)
+}
+
+// AddKey adds a key to the keyring. The first key added becomes the primary key.
+func (k *Keyring) AddKey(id string, key []byte) error {
+	if id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("invalid key %q: %s", id, err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = append([]byte(nil), key...)
+	if k.primary == "" {
+		k.primary = id
+	}
+	return nil
+}
+
+// GenerateKey adds a new random key to the keyring and makes it the primary
+// key. Values encrypted with the previous primary key can still be decrypted;
+// use RotateConfig to re-encrypt them with the new key.
+func (k *Keyring) GenerateKey(id string) error {
+	key := make([]byte, keyringKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if err := k.AddKey(id, key); err != nil {
+		return err
+	}
+	return k.SetPrimary(id)
+}
+
+// SetPrimary sets the key used to encrypt values.
+func (k *Keyring) SetPrimary(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("keyring does not contain key %q", id)
+	}
+	k.primary = id
+	return nil
+}
+
+// Primary returns the id of the key used to encrypt values.
+func (k *Keyring) Primary() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary
+}
+
+// RemoveKey removes a key which is no longer used from the keyring.
+func (k *Keyring) RemoveKey(id string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, id)
+	if k.primary == id {
+		k.primary = ""
+	}
+}
+
+// EncryptValue returns the envelope of v, which may be any JSON value,
+// encrypted with the primary key. path is the JSON pointer of the value in its
+// config, like "/db/password". The key id and path are authenticated along
+// with the value, so the envelope can only be decrypted at the same path.
+func (k *Keyring) EncryptValue(v interface{}, path string) (map[string]interface{}, error) {
+	k.mu.RLock()
+	id, key := k.primary, k.keys[k.primary]
+	k.mu.RUnlock()
+	if id == "" {
+		return nil, errors.New("keyring has no primary key")
+	}
+
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, encryptedValueAAD(id, path))
+
+	return map[string]interface{}{
+		EncryptedValueKey: encryptedValuePrefix + id + ":" + base64.RawURLEncoding.EncodeToString(sealed),
+	}, nil
+}
+
+// DecryptValue returns the value in an envelope produced by EncryptValue for
+// the same path.
+func (k *Keyring) DecryptValue(envelope map[string]interface{}, path string) (interface{}, error) {
+	enc, ok := envelope[EncryptedValueKey].(string)
+	if !ok || len(envelope) != 1 {
+		return nil, errors.New("not an encrypted value")
+	}
+	if !strings.HasPrefix(enc, encryptedValuePrefix) {
+		return nil, fmt.Errorf("unsupported encryption %q", strings.SplitN(enc, ":", 3)[0])
+	}
+	parts := strings.SplitN(strings.TrimPrefix(enc, encryptedValuePrefix), ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed encrypted value")
+	}
+	id := parts[0]
+
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("keyring does not contain key %q", id)
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted value: %s", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("malformed encrypted value")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], encryptedValueAAD(id, path))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting value with key %q: %s", id, err)
+	}
+
+	var v interface{}
+	if err = json.Unmarshal(plaintext, &v); err != nil {
+		return nil, fmt.Errorf("error deserializing decrypted value: %s", err)
+	}
+	return v, nil
+}
+
+// encryptedValueAAD returns the additional data authenticated with the value
+// encrypted by key id at path.
+func encryptedValueAAD(id, path string) []byte {
+	return []byte(encryptedValuePrefix + id + ":" + path)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptConfig returns a copy of config in which the values at the given
+// JSON pointers, like "/db/password", are replaced by their envelopes, for
+// storing secrets in FeatureInstanceInputs.Config. Values which are already
+// encrypted are left alone. Paths with no value, or whose value cannot be
+// encrypted, are reported in a *ConfigEncryptionError.
+func (k *Keyring) EncryptConfig(config interface{}, paths ...string) (interface{}, error) {
+	normalized, err := normalizeJSON(config, false)
+	if err != nil {
+		return nil, err
+	}
+	encryptAt := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		encryptAt[path] = true
+	}
+
+	var errs []ConfigReferenceError
+	out := mapConfigValues(normalized, "", func(v interface{}, path string) (interface{}, bool) {
+		if !encryptAt[path] {
+			return v, false
+		}
+		delete(encryptAt, path)
+		if isEncryptedValue(v) {
+			return v, true
+		}
+		envelope, err := k.EncryptValue(v, path)
+		if err != nil {
+			errs = append(errs, ConfigReferenceError{Path: path, Err: err})
+			return v, true
+		}
+		return envelope, true
+	})
+	for path := range encryptAt {
+		errs = append(errs, ConfigReferenceError{Path: path, Err: errors.New("config has no value at this path")})
+	}
+	if len(errs) > 0 {
+		return nil, newConfigEncryptionError(errs)
+	}
+	return out, nil
+}
+
+// DecryptConfig returns a copy of config in which encrypted values are
+// replaced by their plaintext. Values are only decrypted at the path they
+// were encrypted at. Values which cannot be decrypted are removed,
+// and reported in a *ConfigDecryptionError which is returned along with the
+// rest of the config.
+func (k *Keyring) DecryptConfig(config interface{}) (interface{}, error) {
+	normalized, err := normalizeJSON(config, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []ConfigReferenceError
+	out := mapConfigValues(normalized, "", func(v interface{}, path string) (interface{}, bool) {
+		if !isEncryptedValue(v) {
+			return v, false
+		}
+		plaintext, err := k.DecryptValue(v.(map[string]interface{}), path)
+		if err != nil {
+			errs = append(errs, ConfigReferenceError{Path: path, Reference: EncryptedValueKey, Err: err})
+			return removedConfigValue{}, true
+		}
+		return plaintext, true
+	})
+	if _, removed := out.(removedConfigValue); removed {
+		out = nil
+	}
+	if len(errs) > 0 {
+		return out, newConfigDecryptionError(errs)
+	}
+	return out, nil
+}
+
+// RotateConfig returns a copy of config in which every encrypted value is
+// re-encrypted with the primary key. It returns a *ConfigDecryptionError if
+// any value cannot be decrypted, or else a *ConfigEncryptionError if any
+// value cannot be re-encrypted.
+func (k *Keyring) RotateConfig(config interface{}) (interface{}, error) {
+	normalized, err := normalizeJSON(config, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var decryptErrs, encryptErrs []ConfigReferenceError
+	out := mapConfigValues(normalized, "", func(v interface{}, path string) (interface{}, bool) {
+		if !isEncryptedValue(v) {
+			return v, false
+		}
+		plaintext, err := k.DecryptValue(v.(map[string]interface{}), path)
+		if err != nil {
+			decryptErrs = append(decryptErrs, ConfigReferenceError{Path: path, Reference: EncryptedValueKey, Err: err})
+			return v, true
+		}
+		envelope, err := k.EncryptValue(plaintext, path)
+		if err != nil {
+			encryptErrs = append(encryptErrs, ConfigReferenceError{Path: path, Reference: EncryptedValueKey, Err: err})
+			return v, true
+		}
+		return envelope, true
+	})
+	if len(decryptErrs) > 0 {
+		return nil, newConfigDecryptionError(decryptErrs)
+	}
+	if len(encryptErrs) > 0 {
+		return nil, newConfigEncryptionError(encryptErrs)
+	}
+	return out, nil
+}
+
+// ConfigDecryptionError is returned when encrypted config values could not be
+// decrypted.
+type ConfigDecryptionError struct {
+	Errors []ConfigReferenceError
+}
+
+func newConfigDecryptionError(errs []ConfigReferenceError) *ConfigDecryptionError {
+	sortConfigReferenceErrors(errs)
+	return &ConfigDecryptionError{Errors: errs}
+}
+
+func (e *ConfigDecryptionError) Error() string {
+	return "encrypted config values could not be decrypted: " + configReferenceErrorMessages(e.Errors)
+}
+
+// ConfigEncryptionError is returned when config values could not be
+// encrypted.
+type ConfigEncryptionError struct {
+	Errors []ConfigReferenceError
+}
+
+func newConfigEncryptionError(errs []ConfigReferenceError) *ConfigEncryptionError {
+	sortConfigReferenceErrors(errs)
+	return &ConfigEncryptionError{Errors: errs}
+}
+
+func (e *ConfigEncryptionError) Error() string {
+	return "config values could not be encrypted: " + configReferenceErrorMessages(e.Errors)
+}
+
+func sortConfigReferenceErrors(errs []ConfigReferenceError) {
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+}
+
+func configReferenceErrorMessages(errs []ConfigReferenceError) string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		path := err.Path
+		if path == "" {
+			path = "/"
+		}
+		msgs[i] = fmt.Sprintf("%s: %s", path, err.Err)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// isEncryptedValue returns true if v is an encrypted value's envelope.
+func isEncryptedValue(v interface{}) bool {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return false
+	}
+	_, ok = m[EncryptedValueKey].(string)
+	return ok
+}
+
+// removedConfigValue marks a value which mapConfigValues should remove.
+type removedConfigValue struct{}
+
+// mapConfigValues returns a copy of v, a value produced by json.Unmarshal, in
+// which each value is replaced by fn. If fn returns false, the value's children
+// are mapped too. Values which fn replaces with removedConfigValue are removed
+// from maps, or replaced by nil in arrays.
+func mapConfigValues(v interface{}, path string, fn func(v interface{}, path string) (interface{}, bool)) interface{} {
+	if mapped, done := fn(v, path); done {
+		return mapped
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			mapped := mapConfigValues(child, path+"/"+escapePointer(k), fn)
+			if _, removed := mapped.(removedConfigValue); !removed {
+				out[k] = mapped
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			mapped := mapConfigValues(child, fmt.Sprintf("%s/%d", path, i), fn)
+			if _, removed := mapped.(removedConfigValue); !removed {
+				out[i] = mapped
+			}
+		}
+		return out
+	}
+	return v
+}
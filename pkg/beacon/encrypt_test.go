@@ -0,0 +1,146 @@
+package beacon_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("Keyring", func() {
+
+	var (
+		dir     string
+		keyring *Keyring
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		keyring = NewKeyring()
+		Expect(keyring.GenerateKey("k1")).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should encrypt and decrypt config values", func() {
+		config := map[string]interface{}{
+			"db":   map[string]interface{}{"host": "localhost", "password": "hunter2"},
+			"keys": []interface{}{1, 2},
+		}
+		encrypted, err := keyring.EncryptConfig(config, "/db/password", "/keys")
+		Expect(err).ToNot(HaveOccurred())
+
+		db := encrypted.(map[string]interface{})["db"].(map[string]interface{})
+		Expect(db).To(HaveKeyWithValue("host", "localhost"))
+		Expect(db["password"]).To(HaveKeyWithValue(EncryptedValueKey, HavePrefix("aesgcm:v1:k1:")))
+		Expect(encrypted).To(HaveKeyWithValue("keys", HaveKey(EncryptedValueKey)))
+
+		decrypted, err := keyring.DecryptConfig(encrypted)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decrypted).To(Equal(map[string]interface{}{
+			"db":   map[string]interface{}{"host": "localhost", "password": "hunter2"},
+			"keys": []interface{}{float64(1), float64(2)},
+		}))
+
+		_, err = keyring.EncryptConfig(config, "/db/missing")
+		Expect(err).To(BeAssignableToTypeOf(&ConfigEncryptionError{}))
+		Expect(err).To(MatchError("config values could not be encrypted: /db/missing: config has no value at this path"))
+	})
+
+	It("should report values which cannot be decrypted by path", func() {
+		encrypted, err := keyring.EncryptConfig(map[string]interface{}{"a": "1", "b": "2"}, "/a", "/b")
+		Expect(err).ToNot(HaveOccurred())
+		encrypted.(map[string]interface{})["b"] = map[string]interface{}{EncryptedValueKey: "aesgcm:v1:k9:AAAA"}
+
+		decrypted, err := keyring.DecryptConfig(encrypted)
+		Expect(err).To(BeAssignableToTypeOf(&ConfigDecryptionError{}))
+		errs := err.(*ConfigDecryptionError).Errors
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Path).To(Equal("/b"))
+		Expect(errs[0].Err).To(MatchError(`keyring does not contain key "k9"`))
+		Expect(decrypted).To(Equal(map[string]interface{}{"a": "1"}))
+	})
+
+	It("should not decrypt values moved to another path", func() {
+		encrypted, err := keyring.EncryptConfig(map[string]interface{}{"a": "secret", "b": "public"}, "/a")
+		Expect(err).ToNot(HaveOccurred())
+		moved := map[string]interface{}{"b": encrypted.(map[string]interface{})["a"]}
+
+		decrypted, err := keyring.DecryptConfig(moved)
+		Expect(err).To(BeAssignableToTypeOf(&ConfigDecryptionError{}))
+		Expect(err.(*ConfigDecryptionError).Errors[0].Path).To(Equal("/b"))
+		Expect(decrypted).To(BeEmpty())
+
+		envelope := encrypted.(map[string]interface{})["a"].(map[string]interface{})
+		Expect(keyring.DecryptValue(envelope, "/a")).To(Equal("secret"))
+		_, err = keyring.DecryptValue(envelope, "/b")
+		Expect(err).To(MatchError(ContainSubstring(`error decrypting value with key "k1"`)))
+	})
+
+	It("should rotate keys", func() {
+		encrypted, err := keyring.EncryptConfig(map[string]interface{}{"a": "secret"}, "/a")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(keyring.GenerateKey("k2")).To(Succeed())
+		rotated, err := keyring.RotateConfig(encrypted)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rotated).To(HaveKeyWithValue("a", HaveKeyWithValue(EncryptedValueKey, HavePrefix("aesgcm:v1:k2:"))))
+
+		keyring.RemoveKey("k1")
+		Expect(keyring.DecryptConfig(rotated)).To(Equal(map[string]interface{}{"a": "secret"}))
+		_, err = keyring.DecryptConfig(encrypted)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should save and load keyrings", func() {
+		path := filepath.Join(dir, "keyring.json")
+		Expect(keyring.GenerateKey("k2")).To(Succeed())
+		Expect(keyring.Save(path)).To(Succeed())
+		info, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		encrypted, err := keyring.EncryptConfig(map[string]interface{}{"a": "secret"}, "/a")
+		Expect(err).ToNot(HaveOccurred())
+
+		loaded, err := LoadKeyring(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.Primary()).To(Equal("k2"))
+		Expect(loaded.DecryptConfig(encrypted)).To(Equal(map[string]interface{}{"a": "secret"}))
+	})
+
+	It("should be used by the monitor to decrypt config", func() {
+		encrypted, err := keyring.EncryptConfig(map[string]interface{}{"password": "hunter2", "user": "admin"}, "/password")
+		Expect(err).ToNot(HaveOccurred())
+		doc, err := json.Marshal(map[string]interface{}{"config": encrypted})
+		Expect(err).ToNot(HaveOccurred())
+
+		path := filepath.Join(dir, "instance.json")
+		writeFeatureInstance(path, string(doc))
+		monitor, err := NewFeatureInstanceMonitorWithOptions(NewFileSource(path), MonitorOptions{Keyring: keyring})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(monitor.Config()).To(Equal(map[string]interface{}{"password": "hunter2", "user": "admin"}))
+		Expect(monitor.FeatureInstance().Config).To(HaveKeyWithValue("password", HaveKey(EncryptedValueKey)))
+
+		var cfg struct{ Password, User string }
+		_, err = monitor.Bind(&cfg, BindOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Password).To(Equal("hunter2"))
+
+		other := NewKeyring()
+		Expect(other.GenerateKey("k1")).To(Succeed())
+		monitor, err = NewFeatureInstanceMonitorWithOptions(NewFileSource(path), MonitorOptions{Keyring: other})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(monitor.Config()).To(Equal(map[string]interface{}{"user": "admin"}))
+	})
+})
@@ -9,7 +9,7 @@ import (
 
 type runningExpectation struct {
 	nrn         NRN
-	log         Log
+	log         ScopedLog
 	client      *BaseClient
 	expectation *Expectation
 }
@@ -23,9 +23,9 @@ func (d *runningExpectation) Fulfil(message string) {
 		Message: to.StringPtr(message),
 	})
 	if err != nil {
-		d.log.Error(d.nrn, "Fulfillment failed", err, map[string]interface{}{"message": message})
+		d.log.Error("Fulfillment failed", err, map[string]interface{}{"message": message})
 	}
-	d.log.Debug(d.nrn, "Fulfilled", map[string]interface{}{"message": message})
+	d.log.Debug("Fulfilled", map[string]interface{}{"message": message})
 }
 
 func (d *runningExpectation) Fail(message string) {
@@ -33,9 +33,9 @@ func (d *runningExpectation) Fail(message string) {
 		Message: to.StringPtr(message),
 	})
 	if err != nil {
-		d.log.Error(d.nrn, "Failure failed", err, map[string]interface{}{"message": message})
+		d.log.Error("Failure failed", err, map[string]interface{}{"message": message})
 	}
-	d.log.Debug(d.nrn, "Failed", map[string]interface{}{"message": message})
+	d.log.Debug("Failed", map[string]interface{}{"message": message})
 }
 
 func (d *runningExpectation) Reschedule(message string, rescheduleTo time.Time) {
@@ -44,27 +44,30 @@ func (d *runningExpectation) Reschedule(message string, rescheduleTo time.Time)
 		RescheduleTo: &date.Time{rescheduleTo},
 	})
 	if err != nil {
-		d.log.Error(d.nrn, "Reschedule failed", err, map[string]interface{}{"message": message, "rescheduleTo": rescheduleTo})
+		d.log.Error("Reschedule failed", err, map[string]interface{}{"message": message, "rescheduleTo": rescheduleTo})
 	}
-	d.log.Debug(d.nrn, "Rescheduled.", map[string]interface{}{"message": message, "rescheduleTo": rescheduleTo})
+	d.log.Debug("Rescheduled.", map[string]interface{}{"message": message, "rescheduleTo": rescheduleTo})
 }
 
 func (d *runningExpectation) Retire() {
 	_, err := d.client.DeleteExpectation(timeoutCtx(), to.String(d.expectation.Path))
 	if err != nil {
-		d.log.Error(d.nrn, "Retirement failed", err)
+		d.log.Error("Retirement failed", err)
 	}
-	d.log.Debug(d.nrn, "Retired.")
+	d.log.Debug("Retired.")
 }
 
 // StartHeartbeat starts a heartbeat callback which will fulfil or fail the provided expectation
 // by invoking the provided checker. If the checker returns an error, the expectation will fail;
-// otherwise it will be fulfilled. Invoking the returned function will stop the loop.
+// otherwise it will be fulfilled. Invoking the returned function will stop the loop, and wait
+// for a check in progress to finish.
 func StartHeartbeat(exp RunningExpectation, interval time.Duration, checker func() error) (stop func()) {
 
 	done := make(chan struct{})
+	exited := make(chan struct{})
 
 	go func() {
+		defer close(exited)
 		for {
 			select {
 			case <-done:
@@ -82,5 +85,6 @@ func StartHeartbeat(exp RunningExpectation, interval time.Duration, checker func
 
 	return func() {
 		close(done)
+		<-exited
 	}
 }
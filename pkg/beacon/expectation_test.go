@@ -1,13 +1,14 @@
-package beacon
+package beacon_test
 
 import (
 	"errors"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
-	. "github.com/naveego/beacon-go"
+	. "github.com/naveego/beacon-go/pkg/beacon"
 )
 
 type mockExpectation struct {
@@ -35,22 +36,20 @@ var _ = Describe("Expectation", func() {
 	Describe("Heartbeat", func() {
 
 		It("should invoke callback until stopped", func() {
-			count := 0
+			var count int32
 			exp := new(mockExpectation)
 			stop := StartHeartbeat(exp, time.Millisecond, func() error {
-				count++
-				if count == 2 {
+				if atomic.AddInt32(&count, 1) == 2 {
 					return errors.New("expected")
 				}
 				return nil
 			})
 
-			<-time.After(time.Millisecond * 5)
+			Eventually(func() int32 { return atomic.LoadInt32(&count) }).Should(BeNumerically(">=", 2))
 			stop()
-			finalCount := count
-			Expect(finalCount).To(BeNumerically(">", 0))
+			finalCount := int(atomic.LoadInt32(&count))
 			<-time.After(time.Millisecond * 5)
-			Expect(count).To(Equal(finalCount), "should stop calling after stop")
+			Expect(int(atomic.LoadInt32(&count))).To(Equal(finalCount), "should stop calling after stop")
 			Expect(exp.failMessages).To(HaveLen(1))
 			Expect(exp.fulfilMessages).To(HaveLen(finalCount - 1))
 		})
@@ -0,0 +1,70 @@
+package beacon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// ValidateConfig validates config against the InstanceConfigSchema of the
+// feature. It returns nil if the feature has no schema, or a
+// *SchemaValidationError if the config does not conform.
+func (f Feature) ValidateConfig(config interface{}) error {
+	if f.InstanceConfigSchema == nil {
+		return nil
+	}
+	schema, err := CompileJSONSchema(f.InstanceConfigSchema)
+	if err != nil {
+		return fmt.Errorf("feature %s@%s has an invalid instance config schema: %s", to.String(f.Name), to.String(f.Version), err)
+	}
+	return schema.Validate(config)
+}
+
+// GetFeature returns the feature with the given name and exact version.
+func (client BaseClient) GetFeature(ctx context.Context, name string, version string) (Feature, error) {
+	features, err := client.GetFeatures(ctx, name, version)
+	if err != nil {
+		return Feature{}, err
+	}
+	if features.Value != nil {
+		for _, f := range *features.Value {
+			if to.String(f.Name) == name && to.String(f.Version) == version {
+				return f, nil
+			}
+		}
+	}
+	return Feature{}, fmt.Errorf("feature %s@%s not found", name, version)
+}
+
+// CreateValidatedFeatureInstance validates the config of the instance against the
+// InstanceConfigSchema of its feature before creating it, so that misconfigured
+// instances are rejected before any system tries to use them.
+func (client BaseClient) CreateValidatedFeatureInstance(ctx context.Context, body *FeatureInstanceInputs) (FeatureInstance, error) {
+	feature, err := client.GetFeature(ctx, to.String(body.FeatureName), to.String(body.FeatureVersion))
+	if err != nil {
+		return FeatureInstance{}, err
+	}
+	if err = feature.ValidateConfig(body.Config); err != nil {
+		return FeatureInstance{}, err
+	}
+	return client.CreateFeatureInstance(ctx, body)
+}
+
+// SetConfigSchemaFrom sets InstanceConfigSchema to the schema generated from
+// the config struct, so that the schema registered with CreateFeature can't
+// drift from the struct the feature binds its config to. See GenerateJSONSchema.
+func (f *Feature) SetConfigSchemaFrom(config interface{}) error {
+	schema, err := GenerateJSONSchema(config)
+	if err != nil {
+		return err
+	}
+	f.InstanceConfigSchema = schema
+	return nil
+}
+
+// InstanceNRN returns the NRN of the named instance of f, to set as
+// SystemOptions.FeatureInstanceNRN.
+func (f Feature) InstanceNRN(tenant, instance string) NRN {
+	return FeatureInstanceNRN(tenant, to.String(f.Name), to.String(f.Version), instance)
+}
@@ -0,0 +1,219 @@
+package beacon
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DocumentFormat is the format of a FeatureInstance or config document.
+type DocumentFormat string
+
+// Supported document formats.
+const (
+	FormatJSON DocumentFormat = "json"
+	FormatYAML DocumentFormat = "yaml"
+	FormatTOML DocumentFormat = "toml"
+)
+
+// DocumentError is returned when a document cannot be parsed.
+type DocumentError struct {
+	Format DocumentFormat
+	// Line and Column are 1-based. They are 0 when the position is unknown;
+	// YAML errors only report the line.
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *DocumentError) Error() string {
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("invalid %s: %s", e.Format, e.Message)
+	case e.Column == 0:
+		return fmt.Sprintf("invalid %s at line %d: %s", e.Format, e.Line, e.Message)
+	}
+	return fmt.Sprintf("invalid %s at line %d, column %d: %s", e.Format, e.Line, e.Column, e.Message)
+}
+
+var tomlStatement = regexp.MustCompile(`^(\[|[A-Za-z0-9_."'-]+\s*=)`)
+
+// DetectDocumentFormat returns the format of a document, using the extension
+// of name if it has a known one, and otherwise the content: valid JSON objects
+// and arrays are JSON, before TOML is considered. Query strings and fragments
+// are ignored, so name may be a URL.
+func DetectDocumentFormat(name string, data []byte) DocumentFormat {
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+
+	trimmed := strings.TrimSpace(strings.TrimPrefix(string(data), "\ufeff"))
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return FormatJSON
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "{"):
+			return FormatJSON
+		case tomlStatement.MatchString(line):
+			return FormatTOML
+		}
+		return FormatYAML
+	}
+	return FormatJSON
+}
+
+// DecodeDocument parses a JSON, YAML or TOML document, detected using
+// DetectDocumentFormat, into the values produced by json.Unmarshal.
+func DecodeDocument(name string, data []byte) (interface{}, error) {
+	var out interface{}
+	if err := decodeDocument(name, data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DecodeFeatureInstance parses a FeatureInstance from a JSON, YAML or TOML
+// document, detected using DetectDocumentFormat.
+func DecodeFeatureInstance(name string, data []byte) (*FeatureInstance, error) {
+	featureInstance := new(FeatureInstance)
+	if err := decodeDocument(name, data, featureInstance); err != nil {
+		return nil, err
+	}
+	return featureInstance, nil
+}
+
+// decodeDocument normalizes YAML and TOML documents to JSON, so that they
+// are unmarshalled into the same shapes as JSON documents.
+func decodeDocument(name string, data []byte, to interface{}) error {
+	format := DetectDocumentFormat(name, data)
+
+	var doc interface{}
+	switch format {
+	case FormatJSON:
+		return jsonDocumentError(data, json.Unmarshal(data, to))
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return yamlDocumentError(err)
+		}
+	case FormatTOML:
+		table, err := parseTOML(data)
+		if err != nil {
+			return err
+		}
+		doc = table
+	}
+
+	doc, err := jsonCompatible(doc)
+	if err != nil {
+		return &DocumentError{Format: format, Message: err.Error()}
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return &DocumentError{Format: format, Message: err.Error()}
+	}
+	if err = json.Unmarshal(b, to); err != nil {
+		return &DocumentError{Format: format, Message: err.Error()}
+	}
+	return nil
+}
+
+// jsonCompatible converts the maps produced by YAML, which may have keys
+// of any type, to maps with string keys.
+func jsonCompatible(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			var err error
+			if out[fmt.Sprint(k)], err = jsonCompatible(child); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			var err error
+			if out[k], err = jsonCompatible(child); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			var err error
+			if out[i], err = jsonCompatible(child); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func jsonDocumentError(data []byte, err error) error {
+	var offset int64
+	switch t := err.(type) {
+	case nil:
+		return nil
+	// The offsets are just past the byte, or value, which caused the error.
+	case *json.SyntaxError:
+		offset = t.Offset - 1
+	case *json.UnmarshalTypeError:
+		offset = t.Offset - 1
+	default:
+		return &DocumentError{Format: FormatJSON, Message: err.Error()}
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	line, column := lineAndColumn(string(data), int(offset))
+	return &DocumentError{Format: FormatJSON, Line: line, Column: column, Message: err.Error()}
+}
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+): (.*)`)
+
+// yamlDocumentError extracts the line from a YAML error. The YAML parser
+// does not report columns.
+func yamlDocumentError(err error) error {
+	message := strings.TrimPrefix(err.Error(), "yaml: ")
+	m := yamlErrorLine.FindStringSubmatch(message)
+	if m == nil {
+		return &DocumentError{Format: FormatYAML, Message: message}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return &DocumentError{Format: FormatYAML, Line: line, Message: strings.TrimSpace(m[2])}
+}
+
+// lineAndColumn returns the 1-based line and column, in characters,
+// of the byte offset in s.
+func lineAndColumn(s string, offset int) (line, column int) {
+	if offset > len(s) {
+		offset = len(s)
+	}
+	before := s[:offset]
+	line = strings.Count(before, "\n") + 1
+	lineStart := strings.LastIndex(before, "\n") + 1
+	return line, utf8.RuneCountInString(before[lineStart:]) + 1
+}
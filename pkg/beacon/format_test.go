@@ -0,0 +1,147 @@
+package beacon_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("Documents", func() {
+
+	expectedConfig := map[string]interface{}{
+		"name":  "orders",
+		"db":    map[string]interface{}{"host": "localhost", "port": float64(5432)},
+		"hosts": []interface{}{"a", "b"},
+		"ratio": 0.5,
+		"debug": true,
+	}
+
+	expectInstance := func(fi *FeatureInstance) {
+		Expect(to.String(fi.Path)).To(Equal("features/orders/1.0.0/a"))
+		Expect(to.Bool(fi.IsEnabled)).To(BeTrue())
+		Expect(fi.CreatedAt).ToNot(BeNil())
+		Expect(fi.CreatedAt.Year()).To(Equal(2019))
+		Expect(fi.Config).To(Equal(expectedConfig))
+	}
+
+	It("should decode YAML FeatureInstances", func() {
+		fi, err := DecodeFeatureInstance("instance.yaml", []byte(`
+path: features/orders/1.0.0/a
+isEnabled: true
+createdAt: "2019-05-27T07:32:00Z"
+config:
+  name: orders
+  db:
+    host: localhost
+    port: 5432
+  hosts: [a, b]
+  ratio: 0.5
+  debug: true
+`))
+		Expect(err).ToNot(HaveOccurred())
+		expectInstance(fi)
+	})
+
+	It("should decode TOML FeatureInstances", func() {
+		fi, err := DecodeFeatureInstance("instance.toml", []byte(`
+# An instance of the orders feature.
+path = "features/orders/1.0.0/a"
+isEnabled = true
+createdAt = 2019-05-27T07:32:00Z
+
+[config]
+name = 'orders'
+hosts = [
+  "a",
+  "b", # trailing commas are allowed
+]
+ratio = 5e-1
+debug = true
+db = { host = "localhost", port = 5_432 }
+`))
+		Expect(err).ToNot(HaveOccurred())
+		expectInstance(fi)
+	})
+
+	It("should decode the rest of TOML", func() {
+		doc, err := DecodeDocument("doc.toml", []byte(`
+a.b = 0x1f
+"quoted key" = """
+line one
+line \
+  two\u0021"""
+literal = '''C:\path'''
+
+[[servers]]
+name = "alpha"
+
+[[servers]]
+name = "beta"
+[servers.tls]
+enabled = false
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(doc).To(Equal(map[string]interface{}{
+			"a":          map[string]interface{}{"b": float64(31)},
+			"quoted key": "line one\nline two!",
+			"literal":    `C:\path`,
+			"servers": []interface{}{
+				map[string]interface{}{"name": "alpha"},
+				map[string]interface{}{"name": "beta", "tls": map[string]interface{}{"enabled": false}},
+			},
+		}))
+	})
+
+	It("should detect the format from the content", func() {
+		Expect(DetectDocumentFormat("", []byte(`  {"config": {}}`))).To(Equal(FormatJSON))
+		Expect(DetectDocumentFormat("", []byte(`[{"config": {}}]`))).To(Equal(FormatJSON))
+		Expect(DetectDocumentFormat("", []byte("[\n  1,\n  2\n]"))).To(Equal(FormatJSON))
+		Expect(DetectDocumentFormat("http://example.com/instance", []byte("# comment\n[config]\n"))).To(Equal(FormatTOML))
+		Expect(DetectDocumentFormat("", []byte("key = 1"))).To(Equal(FormatTOML))
+		Expect(DetectDocumentFormat("", []byte("---\nconfig:\n  a: 1\n"))).To(Equal(FormatYAML))
+		Expect(DetectDocumentFormat("http://example.com/instance.yml?version=2", []byte(`{}`))).To(Equal(FormatYAML))
+	})
+
+	It("should report the position of errors", func() {
+		for _, c := range []struct {
+			name, doc    string
+			line, column int
+		}{
+			{"instance.json", "{\n  \"config\": {\n    \"a\": ,\n  }\n}", 3, 10},
+			{"instance.json", "{\n  \"path\": 1\n}", 2, 11},
+			{"instance.yaml", "config:\n  a: 1\n\tb: 2\n", 3, 0},
+			{"instance.toml", "[config]\na = 1\nb = nope\n", 3, 5},
+			{"instance.toml", "a = 1\n  a = 2\n", 2, 3},
+			{"instance.toml", "[a]\n[b]\n[a]\n", 3, 2},
+			{"instance.toml", "a = \"x\nb = 1\n", 1, 7},
+		} {
+			_, err := DecodeFeatureInstance(c.name, []byte(c.doc))
+			Expect(err).To(BeAssignableToTypeOf(&DocumentError{}), c.doc)
+			docErr := err.(*DocumentError)
+			Expect([]int{docErr.Line, docErr.Column}).To(Equal([]int{c.line, c.column}), c.doc)
+		}
+	})
+
+	It("should be monitored in any format", func() {
+		dir, err := ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "instance.yaml")
+		writeFeatureInstance(path, "config:\n  name: orders\n")
+		monitor, err := NewFeatureInstanceMonitor(NewFileSource(path))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(monitor.Config()).To(Equal(map[string]interface{}{"name": "orders"}))
+
+		path = filepath.Join(dir, "instance.toml")
+		writeFeatureInstance(path, "[config]\nname = orders\n")
+		_, err = NewFeatureInstanceMonitor(NewFileSource(path))
+		Expect(err).To(MatchError(HavePrefix("error deserializing config: invalid toml at line 2, column 8")))
+	})
+})
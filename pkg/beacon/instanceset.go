@@ -0,0 +1,336 @@
+package beacon
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// defaultInstanceSetInterval is the default for FeatureInstanceSetOptions.Interval.
+const defaultInstanceSetInterval = 30 * time.Second
+
+// FeatureInstanceFilter selects the instances managed by a FeatureInstanceSet.
+// Empty fields match everything.
+type FeatureInstanceFilter struct {
+	FeatureName    string
+	FeatureVersion string
+	// VersionRange - A SemVer range the feature version must satisfy, like "^1.2.0".
+	VersionRange string
+	Tenant       string
+}
+
+// FeatureInstanceSetOptions configure a FeatureInstanceSet.
+type FeatureInstanceSetOptions struct {
+	Filter FeatureInstanceFilter
+	// Interval is how often Run lists the instances. Defaults to 30 seconds.
+	Interval time.Duration
+	// MaxConcurrency is the most Start callbacks which may run at once, and
+	// the most Stop callbacks; instances wait to start until a Start returns.
+	// Defaults to 1.
+	MaxConcurrency int
+
+	// Start is invoked on its own goroutine when an instance is added, or
+	// after Stop when it changes, and is required. It may run the instance's
+	// work until ctx is done, or start the work and return. ctx is cancelled
+	// when the instance is removed or changes, so work started by Start should
+	// stop when it is done. system is a system started for the instance;
+	// expectations created through it are retired and it is shut down after
+	// Stop. If Start returns an error, the system is shut down and Start is
+	// retried the next time the instances are listed.
+	Start func(ctx context.Context, instance FeatureInstance, system RunningSystem) error
+	// Stop is invoked, after the context passed to Start is cancelled and
+	// Start has returned, when an instance is removed, disabled or changed.
+	// It is not invoked if Start returned an error. Optional.
+	Stop func(instance FeatureInstance)
+
+	// StartSystem starts the system for each instance. If nil, the system is
+	// started by BaseClient.StartSystem using System, with the instance's
+	// path and tenant, and the feature name if System.Name is empty.
+	StartSystem func(instance FeatureInstance) RunningSystem
+	System      SystemOptions
+
+	// Log receives messages about the set, with secrets redacted.
+	Log Log
+}
+
+// FeatureInstanceSet manages a worker for each enabled instance of a feature,
+// for services which implement a feature for many tenants.
+type FeatureInstanceSet struct {
+	client  BaseClient
+	options FeatureInstanceSetOptions
+	log     ScopedLog
+	sem     chan struct{}
+
+	// refreshMu serializes refreshes.
+	refreshMu sync.Mutex
+
+	mu        sync.Mutex
+	instances map[string]*managedInstance
+}
+
+// managedInstance is an instance which is starting or has been started.
+type managedInstance struct {
+	instance FeatureInstance
+	doc      interface{}
+	cancel   func()
+	// done is closed when Start returns, or when the instance is stopped
+	// before Start is invoked; err is then set if Start did not succeed.
+	done   chan struct{}
+	err    error
+	system *trackedSystem
+}
+
+// NewFeatureInstanceSet returns a FeatureInstanceSet which lists instances
+// using client. Call Run to start managing them.
+func NewFeatureInstanceSet(client BaseClient, options FeatureInstanceSetOptions) *FeatureInstanceSet {
+	if options.Interval <= 0 {
+		options.Interval = defaultInstanceSetInterval
+	}
+	if options.MaxConcurrency <= 0 {
+		options.MaxConcurrency = 1
+	}
+	if options.Log == nil {
+		options.Log = EmptyLog{}
+	}
+	options.Log = NewRedactingLog(options.Log)
+
+	return &FeatureInstanceSet{
+		client:  client,
+		options: options,
+		log: NewScopedLog(options.Log, NRN{Type: "ftr", Tenant: options.Filter.Tenant, Feature: options.Filter.FeatureName}).
+			With(map[string]interface{}{"filter": options.Filter}),
+		sem:       make(chan struct{}, options.MaxConcurrency),
+		instances: make(map[string]*managedInstance),
+	}
+}
+
+// Run refreshes the set every Interval until ctx is done, then stops all
+// instances and returns ctx.Err(). Errors listing instances are logged,
+// and the instances already running are left alone until the next refresh.
+func (s *FeatureInstanceSet) Run(ctx context.Context) error {
+	defer s.stopAll()
+	for {
+		if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+			s.log.Error("error listing feature instances", err)
+		}
+		select {
+		case <-time.After(s.options.Interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Refresh lists the instances matching the filter and starts, stops or
+// restarts instances which were added, removed or changed since the last
+// refresh. Disabled instances are treated as removed. The contexts passed to
+// Start are derived from ctx. Refresh returns once the Stop callbacks have
+// returned, without waiting for Start callbacks.
+func (s *FeatureInstanceSet) Refresh(ctx context.Context) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	filter := s.options.Filter
+	list, err := s.client.GetFeatureInstances(ctx, filter.FeatureName, filter.FeatureVersion, filter.VersionRange, "", filter.Tenant)
+	if err != nil {
+		return fmt.Errorf("error listing feature instances: %s", err)
+	}
+
+	listed := make(map[string]FeatureInstance)
+	if list.Value != nil {
+		for _, fi := range *list.Value {
+			if fi.Enabled() {
+				listed[instanceKey(fi)] = fi
+			}
+		}
+	}
+
+	s.mu.Lock()
+	var stop []*managedInstance
+	var start []FeatureInstance
+	for key, m := range s.instances {
+		fi, ok := listed[key]
+		if !ok || !reflect.DeepEqual(m.doc, instanceDoc(fi)) {
+			stop = append(stop, m)
+			delete(s.instances, key)
+		}
+	}
+	for key, fi := range listed {
+		if _, ok := s.instances[key]; !ok {
+			start = append(start, fi)
+		}
+	}
+	s.mu.Unlock()
+
+	s.stop(stop)
+	for _, fi := range start {
+		s.start(ctx, fi)
+	}
+	return nil
+}
+
+// Instances returns the instances which are running or waiting to start.
+func (s *FeatureInstanceSet) Instances() []FeatureInstance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instances := make([]FeatureInstance, 0, len(s.instances))
+	for _, m := range s.instances {
+		instances = append(instances, m.instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instanceKey(instances[i]) < instanceKey(instances[j]) })
+	return instances
+}
+
+// each invokes fn for 0 to n-1, at most MaxConcurrency at a time,
+// and waits for them all to return. It doesn't share the slots held by
+// Start callbacks, which may not return until their instance is stopped.
+func (s *FeatureInstanceSet) each(n int, fn func(i int)) {
+	sem := make(chan struct{}, s.options.MaxConcurrency)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// start adds fi to the set and starts it on its own goroutine once fewer than
+// MaxConcurrency callbacks are running. If Start fails, fi is removed from the
+// set so that it is retried by the next refresh.
+func (s *FeatureInstanceSet) start(ctx context.Context, fi FeatureInstance) {
+	ctx, cancel := context.WithCancel(ctx)
+	m := &managedInstance{
+		instance: fi,
+		doc:      instanceDoc(fi),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.instances[instanceKey(fi)] = m
+	s.mu.Unlock()
+
+	go func() {
+		defer close(m.done)
+		select {
+		case s.sem <- struct{}{}:
+		case <-ctx.Done():
+			m.err = ctx.Err()
+			s.forget(m)
+			return
+		}
+		defer func() { <-s.sem }()
+
+		if m.err = s.run(ctx, m); m.err != nil {
+			cancel()
+			m.system.retire()
+			s.forget(m)
+		}
+	}()
+}
+
+// run starts the system for m and invokes Start.
+func (s *FeatureInstanceSet) run(ctx context.Context, m *managedInstance) error {
+	fi := m.instance
+	log := s.log.With(map[string]interface{}{"instance": instanceKey(fi)})
+	log.Debug("starting feature instance")
+
+	var system RunningSystem
+	if s.options.StartSystem != nil {
+		system = s.options.StartSystem(fi)
+	} else {
+		options := s.options.System
+		options.FeatureInstancePath = to.String(fi.Path)
+		options.FeatureInstanceNRN = NRN{}
+		if options.Tenant == "" {
+			options.Tenant = to.String(fi.Tenant)
+		}
+		if options.Name == "" {
+			options.Name = to.String(fi.FeatureName)
+		}
+		system = s.client.StartSystem(options, s.options.Log)
+	}
+	m.system = &trackedSystem{RunningSystem: system}
+
+	err := s.options.Start(ctx, fi, m.system)
+	if err != nil {
+		log.Error("error starting feature instance", err)
+	}
+	return err
+}
+
+// forget removes m from the set, unless it has been replaced.
+func (s *FeatureInstanceSet) forget(m *managedInstance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := instanceKey(m.instance)
+	if s.instances[key] == m {
+		delete(s.instances, key)
+	}
+}
+
+// stop cancels each instance and waits for its Start to return, then
+// invokes Stop and retires the system of those whose Start succeeded.
+func (s *FeatureInstanceSet) stop(stop []*managedInstance) {
+	for _, m := range stop {
+		s.log.Debug("stopping feature instance", map[string]interface{}{"instance": instanceKey(m.instance)})
+		m.cancel()
+	}
+	var started []*managedInstance
+	for _, m := range stop {
+		<-m.done
+		if m.err == nil {
+			started = append(started, m)
+		}
+	}
+	s.each(len(started), func(i int) {
+		m := started[i]
+		if s.options.Stop != nil {
+			s.options.Stop(m.instance)
+		}
+		m.system.retire()
+	})
+}
+
+// stopAll stops all running instances.
+func (s *FeatureInstanceSet) stopAll() {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	s.mu.Lock()
+	var stop []*managedInstance
+	for key, m := range s.instances {
+		stop = append(stop, m)
+		delete(s.instances, key)
+	}
+	s.mu.Unlock()
+
+	s.stop(stop)
+}
+
+// instanceKey identifies an instance within a FeatureInstanceSet.
+func instanceKey(fi FeatureInstance) string {
+	if fi.Path != nil {
+		return *fi.Path
+	}
+	return fmt.Sprintf("%s:%s:%s:%s", to.String(fi.Tenant), to.String(fi.FeatureName), to.String(fi.FeatureVersion), to.String(fi.InstanceName))
+}
+
+// instanceDoc returns the JSON form of fi used to detect changes,
+// ignoring UpdatedAt, which changes even when nothing else does.
+func instanceDoc(fi FeatureInstance) interface{} {
+	fi.UpdatedAt = nil
+	doc, _ := normalizeJSON(fi, false)
+	return doc
+}
@@ -0,0 +1,187 @@
+package beacon_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("FeatureInstanceSet", func() {
+
+	var (
+		mu        sync.Mutex
+		instances []string
+		query     string
+		server    *httptest.Server
+		events    []string
+		set       *FeatureInstanceSet
+		systems   map[string]*recordingSystem
+	)
+
+	instance := func(name, config string, enabled bool) string {
+		return fmt.Sprintf(`{"path":"nrn:beacon:%[1]s:fin:test-feature:1.0.0:%[1]s::%[1]s","tenant":%[1]q,"featureName":"test-feature","instanceName":%[1]q,"isEnabled":%[3]v,"config":%[2]s}`, name, config, enabled)
+	}
+	setInstances := func(list ...string) {
+		mu.Lock()
+		defer mu.Unlock()
+		instances = list
+	}
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	getEvents := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		e := events
+		events = nil
+		return e
+	}
+	// waitEvents waits for n events, then returns them like getEvents.
+	waitEvents := func(n int) []string {
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(events)
+		}).Should(BeNumerically(">=", n))
+		return getEvents()
+	}
+
+	BeforeEach(func() {
+		events = nil
+		systems = make(map[string]*recordingSystem)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			query = r.URL.RawQuery
+			fmt.Fprintf(w, "[%s]", strings.Join(instances, ","))
+		}))
+
+		set = NewFeatureInstanceSet(NewWithBaseURI(server.URL), FeatureInstanceSetOptions{
+			Filter:         FeatureInstanceFilter{FeatureName: "test-feature", VersionRange: "^1.0.0"},
+			MaxConcurrency: 2,
+			StartSystem: func(fi FeatureInstance) RunningSystem {
+				mu.Lock()
+				defer mu.Unlock()
+				system := &recordingSystem{}
+				systems[to.String(fi.InstanceName)] = system
+				return system
+			},
+			Start: func(ctx context.Context, fi FeatureInstance, system RunningSystem) error {
+				system.Expectation(ExpectationOptions{Name: "work"})
+				record("start " + to.String(fi.InstanceName))
+				go func() {
+					<-ctx.Done()
+					record("done " + to.String(fi.InstanceName))
+				}()
+				return nil
+			},
+			Stop: func(fi FeatureInstance) {
+				record("stop " + to.String(fi.InstanceName))
+			},
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should start, stop and restart instances as they change", func() {
+		ctx := context.Background()
+		setInstances(instance("a", `{"n":1}`, true), instance("b", `{}`, true), instance("c", `{}`, false))
+		Expect(set.Refresh(ctx)).To(Succeed())
+		mu.Lock()
+		Expect(query).To(ContainSubstring("versionRange=%5E1.0.0"))
+		mu.Unlock()
+		Expect(set.Instances()).To(HaveLen(2))
+		Expect(waitEvents(2)).To(ConsistOf("start a", "start b"))
+
+		Expect(set.Refresh(ctx)).To(Succeed())
+		Expect(getEvents()).To(BeEmpty())
+
+		setInstances(instance("a", `{"n":2}`, true), instance("c", `{}`, true))
+		Expect(set.Refresh(ctx)).To(Succeed())
+		Expect(waitEvents(6)).To(ConsistOf(
+			"stop a", "stop b", "done a", "done b", "start a", "start c",
+		))
+		mu.Lock()
+		Expect(systems["b"].Events()).To(Equal([]string{"expectation work", "retire work", "shutdown"}))
+		mu.Unlock()
+	})
+
+	It("should run each worker on its own goroutine", func() {
+		var running int32
+		set = NewFeatureInstanceSet(NewWithBaseURI(server.URL), FeatureInstanceSetOptions{
+			MaxConcurrency: 2,
+			StartSystem: func(fi FeatureInstance) RunningSystem {
+				return &recordingSystem{}
+			},
+			Start: func(ctx context.Context, fi FeatureInstance, system RunningSystem) error {
+				atomic.AddInt32(&running, 1)
+				record("start " + to.String(fi.InstanceName))
+				<-ctx.Done()
+				atomic.AddInt32(&running, -1)
+				return nil
+			},
+			Stop: func(fi FeatureInstance) {
+				record("stop " + to.String(fi.InstanceName))
+			},
+		})
+		getRunning := func() int32 { return atomic.LoadInt32(&running) }
+
+		ctx := context.Background()
+		names := []string{"a", "b", "c"}
+		setInstances(instance("a", `{}`, true), instance("b", `{}`, true), instance("c", `{}`, true))
+		Expect(set.Refresh(ctx)).To(Succeed())
+		started := waitEvents(2)
+		Consistently(getRunning).Should(BeEquivalentTo(2), "a worker's slot is held until Start returns")
+		Expect(set.Instances()).To(HaveLen(3))
+
+		var waiting string
+		for _, name := range names {
+			if started[0] != "start "+name && started[1] != "start "+name {
+				waiting = name
+			}
+		}
+		removed := strings.TrimPrefix(started[0], "start ")
+		var remaining []string
+		for _, name := range names {
+			if name != removed {
+				remaining = append(remaining, instance(name, `{}`, true))
+			}
+		}
+		setInstances(remaining...)
+		Expect(set.Refresh(ctx)).To(Succeed())
+		Expect(waitEvents(2)).To(ConsistOf("stop "+removed, "start "+waiting))
+
+		setInstances()
+		Expect(set.Refresh(ctx)).To(Succeed())
+		Expect(getRunning()).To(BeZero())
+		Expect(getEvents()).To(HaveLen(2))
+	})
+
+	It("should run until the context is done", func() {
+		setInstances(instance("a", `{}`, true))
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error, 1)
+		go func() { result <- set.Run(ctx) }()
+
+		Eventually(set.Instances).Should(HaveLen(1))
+		cancel()
+		Eventually(result, time.Second).Should(Receive(Equal(context.Canceled)))
+		Expect(set.Instances()).To(BeEmpty())
+		Eventually(getEvents).Should(ContainElement("stop a"))
+	})
+})
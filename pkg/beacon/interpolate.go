@@ -0,0 +1,198 @@
+package beacon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrConfigReferenceNotFound is returned by a ConfigResolver when the value a
+// reference refers to does not exist, so that the next alternative is tried.
+var ErrConfigReferenceNotFound = errors.New("not found")
+
+// ConfigResolver resolves a config reference like ${scheme:value}.
+// It is passed the value, which is everything after the first colon.
+type ConfigResolver func(value string) (string, error)
+
+var (
+	configResolverMu sync.Mutex
+	configResolvers  = map[string]ConfigResolver{
+		"env":     resolveEnv,
+		"file":    resolveFile,
+		"default": resolveDefault,
+	}
+)
+
+// RegisterConfigResolver registers a resolver for references with the given
+// scheme, for secret backends and the like. Registering a scheme again
+// replaces the previous resolver.
+func RegisterConfigResolver(scheme string, resolver ConfigResolver) {
+	configResolverMu.Lock()
+	defer configResolverMu.Unlock()
+	configResolvers[scheme] = resolver
+}
+
+func resolveEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", ErrConfigReferenceNotFound
+	}
+	return value, nil
+}
+
+func resolveFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", ErrConfigReferenceNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	// Secret files usually end with a newline which isn't part of the secret.
+	s := strings.TrimSuffix(string(b), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
+
+func resolveDefault(value string) (string, error) {
+	return value, nil
+}
+
+// ConfigReferenceError is a config reference which could not be resolved.
+type ConfigReferenceError struct {
+	// Path is the JSON pointer to the value containing the reference.
+	Path      string
+	Reference string
+	Err       error
+}
+
+func (e ConfigReferenceError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: error resolving %s: %s", path, e.Reference, e.Err)
+}
+
+// ConfigResolutionError is returned when references in a config could not be resolved.
+type ConfigResolutionError struct {
+	Errors []ConfigReferenceError
+}
+
+func (e *ConfigResolutionError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("config references could not be resolved: %s", strings.Join(msgs, "; "))
+}
+
+// ResolveConfig returns a copy of config in which references in string
+// values, in nested maps and arrays, are replaced by the values they refer to:
+//
+//	${env:DB_PASSWORD}                the environment variable DB_PASSWORD
+//	${file:/run/secrets/db}           the contents of the file, without a trailing newline
+//	${default:VALUE}                  VALUE
+//	${env:DB_HOST|default:localhost}  the first alternative which exists
+//
+// Schemes added by RegisterConfigResolver are also supported. References may
+// be embedded in longer strings, and $${ is replaced by a literal ${.
+// If any reference cannot be resolved a *ConfigResolutionError naming
+// the path of each one is returned.
+func ResolveConfig(config interface{}) (interface{}, error) {
+	normalized, err := normalizeJSON(config, false)
+	if err != nil {
+		return nil, err
+	}
+	var errs []ConfigReferenceError
+	resolved := resolveConfigValue(normalized, "", &errs)
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+		return nil, &ConfigResolutionError{Errors: errs}
+	}
+	return resolved, nil
+}
+
+func resolveConfigValue(v interface{}, path string, errs *[]ConfigReferenceError) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			out[k] = resolveConfigValue(child, path+"/"+escapePointer(k), errs)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			out[i] = resolveConfigValue(child, fmt.Sprintf("%s/%d", path, i), errs)
+		}
+		return out
+	case string:
+		return resolveConfigString(t, path, errs)
+	}
+	return v
+}
+
+func resolveConfigString(s string, path string, errs *[]ConfigReferenceError) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+
+	var out bytes.Buffer
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			out.WriteString(s)
+			return out.String()
+		}
+		if i > 0 && s[i-1] == '$' {
+			out.WriteString(s[:i-1])
+			out.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		out.WriteString(s[:i])
+
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			*errs = append(*errs, ConfigReferenceError{Path: path, Reference: s[i:], Err: errors.New("unterminated reference")})
+			return out.String()
+		}
+		ref := s[i : i+end+1]
+		value, err := resolveConfigReference(ref[2 : len(ref)-1])
+		if err != nil {
+			*errs = append(*errs, ConfigReferenceError{Path: path, Reference: ref, Err: err})
+		}
+		out.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
+
+// resolveConfigReference resolves the alternatives in a reference,
+// like "env:DB_HOST|default:localhost".
+func resolveConfigReference(ref string) (string, error) {
+	for _, alternative := range strings.Split(ref, "|") {
+		parts := strings.SplitN(alternative, ":", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("%q is not in the form scheme:value", alternative)
+		}
+
+		configResolverMu.Lock()
+		resolver, ok := configResolvers[parts[0]]
+		configResolverMu.Unlock()
+		if !ok {
+			return "", fmt.Errorf("no resolver is registered for %q", parts[0])
+		}
+
+		value, err := resolver(parts[1])
+		if err == ErrConfigReferenceNotFound {
+			continue
+		}
+		return value, err
+	}
+	return "", ErrConfigReferenceNotFound
+}
@@ -0,0 +1,202 @@
+package beacon
+
+import (
+	"context"
+	"sync"
+)
+
+// Enabled returns true unless the FeatureInstance has been disabled.
+// A FeatureInstance with no IsEnabled value is considered enabled.
+func (s FeatureInstance) Enabled() bool {
+	return s.IsEnabled == nil || *s.IsEnabled
+}
+
+// OnEnabled invokes fn with the FeatureInstance whenever it becomes enabled,
+// including immediately if it is currently enabled. Invocations are made as
+// described by OnChange, so an instance which is disabled and re-enabled
+// between refreshes is not reported. Invoking the returned function stops
+// the subscription.
+func (s *FeatureInstanceMonitor) OnEnabled(fn func(FeatureInstance)) (stop func()) {
+	return s.onEnabledChange(true, fn)
+}
+
+// OnDisabled invokes fn with the FeatureInstance whenever it becomes disabled,
+// including immediately if it is currently disabled. Invocations are made as
+// described by OnChange. Invoking the returned function stops the subscription.
+func (s *FeatureInstanceMonitor) OnDisabled(fn func(FeatureInstance)) (stop func()) {
+	return s.onEnabledChange(false, fn)
+}
+
+func (s *FeatureInstanceMonitor) onEnabledChange(enabled bool, fn func(FeatureInstance)) (stop func()) {
+	var known, last bool
+	return s.OnChange(func(change ConfigChange) {
+		current := change.New.Enabled()
+		if (!known || current != last) && current == enabled {
+			fn(change.New)
+		}
+		known, last = true, current
+	})
+}
+
+// RunWhileEnabled runs fn whenever the FeatureInstance is enabled, until ctx
+// is done. Each time the instance becomes enabled a system is started using
+// MonitorOptions.StartSystem, or a dummy system which only logs if that is
+// not set, and fn is invoked with it on a new goroutine. When the instance
+// becomes disabled the context passed to fn is cancelled and, once fn has
+// returned, the expectations and child systems created through the system
+// are retired and the system is shut down.
+//
+// If fn returns while the instance is still enabled, the system is retired
+// and RunWhileEnabled returns the error from fn. Otherwise RunWhileEnabled
+// returns ctx.Err() once ctx is done, or nil if the monitor stops watching
+// for changes.
+func (s *FeatureInstanceMonitor) RunWhileEnabled(ctx context.Context, fn func(ctx context.Context, system RunningSystem) error) error {
+	changes := s.SubscribeChanges()
+	defer s.UnsubscribeChanges(changes)
+
+	var w *enabledWorker
+	defer func() {
+		if w != nil {
+			w.stop()
+		}
+	}()
+
+	for {
+		var done <-chan error
+		if w != nil {
+			done = w.done
+		}
+
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return nil
+			}
+			enabled := change.New.Enabled()
+			if enabled && w == nil {
+				s.logger().Debug("feature instance enabled, starting worker")
+				w = s.startWorker(ctx, change.New, fn)
+			} else if !enabled && w != nil {
+				s.logger().Debug("feature instance disabled, stopping worker")
+				if err := w.stop(); err != nil && err != context.Canceled {
+					s.logger().Warn("worker stopped with an error", map[string]interface{}{"error": err})
+				}
+				w = nil
+			}
+		case err := <-done:
+			w.system.retire()
+			w = nil
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// enabledWorker is an invocation of the function passed to RunWhileEnabled.
+type enabledWorker struct {
+	cancel func()
+	done   chan error
+	system *trackedSystem
+}
+
+func (s *FeatureInstanceMonitor) startWorker(ctx context.Context, fi FeatureInstance, fn func(ctx context.Context, system RunningSystem) error) *enabledWorker {
+	var system RunningSystem
+	if s.options.StartSystem != nil {
+		system = s.options.StartSystem(fi)
+	} else {
+		log := s.logger()
+		system = &dummySystem{nrn: log.Source(), log: log}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &enabledWorker{
+		cancel: cancel,
+		done:   make(chan error, 1),
+		system: &trackedSystem{RunningSystem: system},
+	}
+	go func() {
+		w.done <- fn(ctx, w.system)
+	}()
+	return w
+}
+
+// stop cancels the worker, waits for it to return, and retires its system.
+func (w *enabledWorker) stop() error {
+	w.cancel()
+	err := <-w.done
+	w.system.retire()
+	return err
+}
+
+// trackedSystem is a RunningSystem which records the systems and
+// expectations created through it, so that they can be retired together.
+type trackedSystem struct {
+	RunningSystem
+
+	mu           sync.Mutex
+	shutdown     bool
+	children     []*trackedSystem
+	expectations []*trackedExpectation
+}
+
+func (t *trackedSystem) Child(options SystemOptions) RunningSystem {
+	child := &trackedSystem{RunningSystem: t.RunningSystem.Child(options)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.children = append(t.children, child)
+	return child
+}
+
+func (t *trackedSystem) Expectation(options ExpectationOptions) RunningExpectation {
+	exp := &trackedExpectation{RunningExpectation: t.RunningSystem.Expectation(options)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expectations = append(t.expectations, exp)
+	return exp
+}
+
+func (t *trackedSystem) Shutdown() {
+	t.mu.Lock()
+	shutdown := t.shutdown
+	t.shutdown = true
+	t.mu.Unlock()
+	if !shutdown {
+		t.RunningSystem.Shutdown()
+	}
+}
+
+// retire retires the expectations and child systems created through t,
+// most recent first, and then shuts t down.
+func (t *trackedSystem) retire() {
+	t.mu.Lock()
+	children := t.children
+	expectations := t.expectations
+	t.mu.Unlock()
+
+	for i := len(children) - 1; i >= 0; i-- {
+		children[i].retire()
+	}
+	for i := len(expectations) - 1; i >= 0; i-- {
+		expectations[i].Retire()
+	}
+	t.Shutdown()
+}
+
+// trackedExpectation is a RunningExpectation which is only retired once.
+type trackedExpectation struct {
+	RunningExpectation
+
+	mu      sync.Mutex
+	retired bool
+}
+
+func (t *trackedExpectation) Retire() {
+	t.mu.Lock()
+	retired := t.retired
+	t.retired = true
+	t.mu.Unlock()
+	if !retired {
+		t.RunningExpectation.Retire()
+	}
+}
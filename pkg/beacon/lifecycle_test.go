@@ -0,0 +1,166 @@
+package beacon_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+// recordingSystem is a RunningSystem which records the calls made to it
+// and to the expectations created through it.
+type recordingSystem struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordingSystem) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingSystem) Events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *recordingSystem) Child(options SystemOptions) RunningSystem {
+	r.record("child " + options.Name)
+	return r
+}
+
+func (r *recordingSystem) Expectation(options ExpectationOptions) RunningExpectation {
+	r.record("expectation " + options.Name)
+	return &recordingSystemExpectation{system: r, name: options.Name}
+}
+
+func (r *recordingSystem) Shutdown() {
+	r.record("shutdown")
+}
+
+type recordingSystemExpectation struct {
+	recordingExpectation
+	system *recordingSystem
+	name   string
+}
+
+func (r *recordingSystemExpectation) Retire() {
+	r.system.record("retire " + r.name)
+}
+
+var _ = Describe("FeatureInstanceMonitor lifecycle", func() {
+
+	var (
+		dir     string
+		path    string
+		monitor *FeatureInstanceMonitor
+		system  *recordingSystem
+	)
+
+	setEnabled := func(enabled bool) {
+		if enabled {
+			writeFeatureInstance(path, `{"isEnabled":true}`)
+		} else {
+			writeFeatureInstance(path, `{"isEnabled":false}`)
+		}
+		_, err := monitor.Refresh()
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "instance.json")
+		writeFeatureInstance(path, `{}`)
+		system = &recordingSystem{}
+		monitor, err = NewFeatureInstanceMonitorWithOptions(NewFileSource(path), MonitorOptions{
+			StartSystem: func(fi FeatureInstance) RunningSystem {
+				system.record("start")
+				return system
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should invoke hooks when the instance is enabled and disabled", func() {
+		var (
+			mu     sync.Mutex
+			events []string
+		)
+		record := func(event string) func(FeatureInstance) {
+			return func(FeatureInstance) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, event)
+			}
+		}
+		getEvents := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), events...)
+		}
+
+		defer monitor.OnEnabled(record("enabled"))()
+		Eventually(getEvents).Should(Equal([]string{"enabled"}))
+		defer monitor.OnDisabled(record("disabled"))()
+
+		setEnabled(true)
+		Consistently(getEvents, 50*time.Millisecond).Should(Equal([]string{"enabled"}))
+		setEnabled(false)
+		Eventually(getEvents).Should(Equal([]string{"enabled", "disabled"}))
+		setEnabled(true)
+		Eventually(getEvents).Should(Equal([]string{"enabled", "disabled", "enabled"}))
+	})
+
+	It("should run a worker and its system while the instance is enabled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error, 1)
+		go func() {
+			result <- monitor.RunWhileEnabled(ctx, func(ctx context.Context, system RunningSystem) error {
+				system.Expectation(ExpectationOptions{Name: "work"})
+				<-ctx.Done()
+				return ctx.Err()
+			})
+		}()
+
+		Eventually(system.Events).Should(Equal([]string{"start", "expectation work"}))
+
+		setEnabled(false)
+		Eventually(system.Events).Should(Equal([]string{
+			"start", "expectation work", "retire work", "shutdown",
+		}))
+
+		setEnabled(true)
+		Eventually(system.Events).Should(HaveLen(6))
+
+		cancel()
+		Eventually(result).Should(Receive(Equal(context.Canceled)))
+		Expect(system.Events()[4:]).To(Equal([]string{
+			"start", "expectation work", "retire work", "shutdown",
+		}))
+	})
+
+	It("should return the error from a worker which stops by itself", func() {
+		failure := errors.New("worker failed")
+		err := monitor.RunWhileEnabled(context.Background(), func(ctx context.Context, system RunningSystem) error {
+			return failure
+		})
+		Expect(err).To(Equal(failure))
+		Expect(system.Events()).To(Equal([]string{"start", "shutdown"}))
+	})
+})
@@ -1,7 +1,13 @@
 package beacon
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Log is the logging abstraction for the beacon client.
@@ -14,6 +20,43 @@ type Log interface {
 	Error(source NRN, msg string, err error, data ...map[string]interface{})
 }
 
+// Level is the severity of a log message.
+type Level int
+
+const (
+	// LevelDebug is for detail useful when diagnosing problems.
+	LevelDebug Level = iota
+	// LevelWarn is for problems the client recovered from.
+	LevelWarn
+	// LevelError is for failures which need attention.
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel returns the Level with the given name ("debug", "warn" or "error").
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug", "dbg":
+		return LevelDebug, nil
+	case "warn", "warning", "wrn":
+		return LevelWarn, nil
+	case "error", "err":
+		return LevelError, nil
+	}
+	return LevelDebug, fmt.Errorf("invalid log level %q", s)
+}
+
 type ConsoleLog struct{}
 
 func (c ConsoleLog) Debug(source NRN, msg string, data ...map[string]interface{}) {
@@ -36,3 +79,318 @@ func (c EmptyLog) Warn(source NRN, msg string, data ...map[string]interface{}) {
 }
 func (c EmptyLog) Error(source NRN, msg string, err error, data ...map[string]interface{}) {
 }
+
+// JSONLog writes each message to a writer as a single line of JSON.
+type JSONLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLog returns a JSONLog which writes to w.
+func NewJSONLog(w io.Writer) *JSONLog {
+	return &JSONLog{w: w}
+}
+
+func (j *JSONLog) Debug(source NRN, msg string, data ...map[string]interface{}) {
+	j.write(LevelDebug, source, msg, nil, data)
+}
+func (j *JSONLog) Warn(source NRN, msg string, data ...map[string]interface{}) {
+	j.write(LevelWarn, source, msg, nil, data)
+}
+func (j *JSONLog) Error(source NRN, msg string, err error, data ...map[string]interface{}) {
+	j.write(LevelError, source, msg, err, data)
+}
+
+type jsonLogEntry struct {
+	Timestamp string                 `json:"timestamp"`
+	Level     string                 `json:"level"`
+	NRN       jsonLogNRN             `json:"nrn"`
+	Message   string                 `json:"message"`
+	Error     string                 `json:"error,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+type jsonLogNRN struct {
+	Path     string `json:"path"`
+	Type     string `json:"type,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+	Feature  string `json:"feature,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	System   string `json:"system,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+func (j *JSONLog) write(level Level, source NRN, msg string, err error, data []map[string]interface{}) {
+	entry := jsonLogEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     level.String(),
+		NRN: jsonLogNRN{
+			Path:     source.String(),
+			Type:     source.Type,
+			Tenant:   source.Tenant,
+			Feature:  source.Feature,
+			Version:  source.Version,
+			Instance: source.Instance,
+			System:   source.System,
+			Name:     source.Name,
+		},
+		Message: msg,
+		Data:    mergeLogData(data),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	b, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		// Some value in the data could not be serialized,
+		// so fall back to formatting each value as a string.
+		for k, v := range entry.Data {
+			if _, e := json.Marshal(v); e != nil {
+				entry.Data[k] = fmt.Sprintf("%+v", v)
+			}
+		}
+		b, _ = json.Marshal(entry)
+	}
+	b = append(b, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.w.Write(b)
+}
+
+// mergeLogData merges the data maps passed to a Log method into a single
+// map. Later maps take precedence. Errors are converted to their messages,
+// because they would otherwise usually serialize as empty objects.
+func mergeLogData(data []map[string]interface{}) map[string]interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	merged := make(map[string]interface{})
+	for _, d := range data {
+		for k, v := range d {
+			if e, ok := v.(error); ok {
+				v = e.Error()
+			}
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// LevelLog is a Log which only passes messages at or above
+// Level through to the wrapped Log.
+type LevelLog struct {
+	Log   Log
+	Level Level
+}
+
+func (l LevelLog) Debug(source NRN, msg string, data ...map[string]interface{}) {
+	if l.Level <= LevelDebug {
+		l.Log.Debug(source, msg, data...)
+	}
+}
+func (l LevelLog) Warn(source NRN, msg string, data ...map[string]interface{}) {
+	if l.Level <= LevelWarn {
+		l.Log.Warn(source, msg, data...)
+	}
+}
+func (l LevelLog) Error(source NRN, msg string, err error, data ...map[string]interface{}) {
+	if l.Level <= LevelError {
+		l.Log.Error(source, msg, err, data...)
+	}
+}
+
+// SampledLog is a Log which rate-limits identical messages from the same NRN.
+// The first occurrence of a message is passed through, and any repeats within
+// the interval are dropped. The next message passed through after repeats were
+// dropped has a "suppressed" count added to its data.
+type SampledLog struct {
+	log      Log
+	interval time.Duration
+	mu       sync.Mutex
+	samples  map[string]*logSample
+}
+
+type logSample struct {
+	next       time.Time
+	suppressed int
+}
+
+// maxLogSamples is the number of messages SampledLog tracks. When it is
+// reached expired samples are discarded, and if there are none the oldest.
+const maxLogSamples = 1000
+
+// NewSampledLog returns a SampledLog which passes at most one of each message
+// from each NRN through to log in each interval.
+func NewSampledLog(log Log, interval time.Duration) *SampledLog {
+	return &SampledLog{
+		log:      log,
+		interval: interval,
+		samples:  make(map[string]*logSample),
+	}
+}
+
+func (s *SampledLog) Debug(source NRN, msg string, data ...map[string]interface{}) {
+	if d, ok := s.sample(LevelDebug, source, msg, data); ok {
+		s.log.Debug(source, msg, d...)
+	}
+}
+func (s *SampledLog) Warn(source NRN, msg string, data ...map[string]interface{}) {
+	if d, ok := s.sample(LevelWarn, source, msg, data); ok {
+		s.log.Warn(source, msg, d...)
+	}
+}
+func (s *SampledLog) Error(source NRN, msg string, err error, data ...map[string]interface{}) {
+	if d, ok := s.sample(LevelError, source, msg, data); ok {
+		s.log.Error(source, msg, err, d...)
+	}
+}
+
+// sample returns true if the message should be logged, along
+// with the data it should be logged with.
+func (s *SampledLog) sample(level Level, source NRN, msg string, data []map[string]interface{}) ([]map[string]interface{}, bool) {
+	key := fmt.Sprintf("%d|%s|%s", level, source, msg)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample, ok := s.samples[key]
+	if !ok {
+		if len(s.samples) >= maxLogSamples {
+			var oldest string
+			for k, v := range s.samples {
+				if now.After(v.next) {
+					delete(s.samples, k)
+				} else if oldest == "" || v.next.Before(s.samples[oldest].next) {
+					oldest = k
+				}
+			}
+			if len(s.samples) >= maxLogSamples {
+				delete(s.samples, oldest)
+			}
+		}
+		s.samples[key] = &logSample{next: now.Add(s.interval)}
+		return data, true
+	}
+
+	if now.Before(sample.next) {
+		sample.suppressed++
+		return nil, false
+	}
+
+	if sample.suppressed > 0 {
+		data = append(data[:len(data):len(data)], map[string]interface{}{"suppressed": sample.suppressed})
+	}
+	sample.next = now.Add(s.interval)
+	sample.suppressed = 0
+	return data, true
+}
+
+// FieldLog is an optional interface for a Log which can natively
+// create a child Log that includes fields in every message.
+type FieldLog interface {
+	Log
+	// With returns a Log which includes fields in the data of every message.
+	With(fields map[string]interface{}) Log
+}
+
+// WithFields returns a Log which includes fields in the data of every
+// message written to log. If log implements FieldLog its With method is used,
+// otherwise log is wrapped. Data passed with a message takes precedence
+// over fields with the same key.
+func WithFields(log Log, fields map[string]interface{}) Log {
+	if len(fields) == 0 {
+		return log
+	}
+	if f, ok := log.(FieldLog); ok {
+		return f.With(fields)
+	}
+	return fieldLog{log: log, fields: fields}
+}
+
+type fieldLog struct {
+	log    Log
+	fields map[string]interface{}
+}
+
+func (f fieldLog) With(fields map[string]interface{}) Log {
+	merged := make(map[string]interface{}, len(f.fields)+len(fields))
+	for k, v := range f.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return fieldLog{log: f.log, fields: merged}
+}
+
+func (f fieldLog) Debug(source NRN, msg string, data ...map[string]interface{}) {
+	f.log.Debug(source, msg, f.data(data)...)
+}
+func (f fieldLog) Warn(source NRN, msg string, data ...map[string]interface{}) {
+	f.log.Warn(source, msg, f.data(data)...)
+}
+func (f fieldLog) Error(source NRN, msg string, err error, data ...map[string]interface{}) {
+	f.log.Error(source, msg, err, f.data(data)...)
+}
+
+func (f fieldLog) data(data []map[string]interface{}) []map[string]interface{} {
+	return append([]map[string]interface{}{f.fields}, data...)
+}
+
+// ScopedLog is a Log bound to a source NRN and, optionally, a set of fields,
+// so that callers don't have to repeat them with every message.
+type ScopedLog struct {
+	source NRN
+	root   Log
+	log    Log
+}
+
+// NewScopedLog returns a ScopedLog which writes messages from source to log.
+func NewScopedLog(log Log, source NRN) ScopedLog {
+	return ScopedLog{
+		source: source,
+		root:   log,
+		log:    log,
+	}
+}
+
+// Source returns the NRN messages are written from.
+func (s ScopedLog) Source() NRN {
+	return s.source
+}
+
+// Root returns the Log this ScopedLog was created from, without any fields.
+func (s ScopedLog) Root() Log {
+	return s.root
+}
+
+// With returns a copy of s which includes fields in every message.
+func (s ScopedLog) With(fields map[string]interface{}) ScopedLog {
+	s.log = WithFields(s.log, fields)
+	return s
+}
+
+// Scope returns a ScopedLog for messages from source, writing to the same
+// root Log as s but without any of the fields added to s.
+func (s ScopedLog) Scope(source NRN) ScopedLog {
+	return NewScopedLog(s.root, source)
+}
+
+// Debug logs a debug message.
+func (s ScopedLog) Debug(msg string, data ...map[string]interface{}) {
+	s.log.Debug(s.source, msg, data...)
+}
+
+// Warn logs a warning message.
+func (s ScopedLog) Warn(msg string, data ...map[string]interface{}) {
+	s.log.Warn(s.source, msg, data...)
+}
+
+// Error logs an error message.
+func (s ScopedLog) Error(msg string, err error, data ...map[string]interface{}) {
+	s.log.Error(s.source, msg, err, data...)
+}
@@ -0,0 +1,219 @@
+package beacon_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+type logEntry struct {
+	level  Level
+	source NRN
+	msg    string
+	err    error
+	data   []map[string]interface{}
+}
+
+type recordingLog struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (r *recordingLog) record(e logEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+}
+
+func (r *recordingLog) Entries() []logEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]logEntry(nil), r.entries...)
+}
+
+func (r *recordingLog) Debug(source NRN, msg string, data ...map[string]interface{}) {
+	r.record(logEntry{level: LevelDebug, source: source, msg: msg, data: data})
+}
+func (r *recordingLog) Warn(source NRN, msg string, data ...map[string]interface{}) {
+	r.record(logEntry{level: LevelWarn, source: source, msg: msg, data: data})
+}
+func (r *recordingLog) Error(source NRN, msg string, err error, data ...map[string]interface{}) {
+	r.record(logEntry{level: LevelError, source: source, msg: msg, err: err, data: data})
+}
+
+var _ = Describe("Log", func() {
+
+	source := FeatureInstanceNRN("test-tenant", "feature-A", "1.0.0", "instance-1").ChildSystem("system-A")
+
+	Describe("JSONLog", func() {
+
+		It("should write one object per line", func() {
+			buf := new(bytes.Buffer)
+			sut := NewJSONLog(buf)
+
+			sut.Debug(source, "first", map[string]interface{}{"a": 1}, map[string]interface{}{"b": "x", "a": 2})
+			sut.Error(source, "second", errors.New("boom"), map[string]interface{}{"cause": errors.New("inner"), "ch": make(chan int)})
+
+			lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+			Expect(lines).To(HaveLen(2))
+
+			var first map[string]interface{}
+			Expect(json.Unmarshal(lines[0], &first)).To(Succeed())
+			Expect(first).To(HaveKeyWithValue("level", "debug"))
+			Expect(first).To(HaveKeyWithValue("message", "first"))
+			Expect(first).To(HaveKey("timestamp"))
+			Expect(first["nrn"]).To(HaveKeyWithValue("path", source.String()))
+			Expect(first["nrn"]).To(HaveKeyWithValue("tenant", "test-tenant"))
+			Expect(first["nrn"]).To(HaveKeyWithValue("type", "sys"))
+			Expect(first["nrn"]).To(HaveKeyWithValue("name", "system-A"))
+			Expect(first["data"]).To(Equal(map[string]interface{}{"a": float64(2), "b": "x"}))
+			Expect(first).ToNot(HaveKey("error"))
+
+			var second map[string]interface{}
+			Expect(json.Unmarshal(lines[1], &second)).To(Succeed())
+			Expect(second).To(HaveKeyWithValue("level", "error"))
+			Expect(second).To(HaveKeyWithValue("error", "boom"))
+			Expect(second["data"]).To(HaveKeyWithValue("cause", "inner"))
+			Expect(second["data"]).To(HaveKey("ch"))
+		})
+	})
+
+	Describe("LevelLog", func() {
+
+		It("should drop messages below level", func() {
+			rec := new(recordingLog)
+			sut := LevelLog{Log: rec, Level: LevelWarn}
+
+			sut.Debug(source, "debug")
+			sut.Warn(source, "warn")
+			sut.Error(source, "error", errors.New("boom"))
+
+			Expect(rec.Entries()).To(HaveLen(2))
+			Expect(rec.Entries()[0].msg).To(Equal("warn"))
+			Expect(rec.Entries()[1].msg).To(Equal("error"))
+		})
+
+		It("should parse levels", func() {
+			Expect(ParseLevel("WARN")).To(Equal(LevelWarn))
+			_, err := ParseLevel("loud")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("SampledLog", func() {
+
+		It("should suppress repeated messages within interval", func() {
+			rec := new(recordingLog)
+			sut := NewSampledLog(rec, 20*time.Millisecond)
+
+			for i := 0; i < 5; i++ {
+				sut.Warn(source, "repeated")
+			}
+			sut.Warn(source, "different")
+			sut.Warn(source.ChildSystem("other"), "repeated")
+
+			Expect(rec.Entries()).To(HaveLen(3))
+
+			<-time.After(30 * time.Millisecond)
+			sut.Warn(source, "repeated")
+
+			entries := rec.Entries()
+			Expect(entries).To(HaveLen(4))
+			Expect(entries[3].data).To(ContainElement(HaveKeyWithValue("suppressed", 4)))
+		})
+
+		It("should forget the oldest message when too many are tracked", func() {
+			rec := new(recordingLog)
+			sut := NewSampledLog(rec, time.Hour)
+
+			sut.Warn(source, "first")
+			for i := 0; i < 1000; i++ {
+				sut.Warn(source, fmt.Sprintf("message %d", i))
+			}
+			sut.Warn(source, "first")
+			sut.Warn(source, "message 999")
+
+			Expect(rec.Entries()).To(HaveLen(1002))
+		})
+	})
+
+	Describe("WithFields", func() {
+
+		It("should include fields in every message", func() {
+			rec := new(recordingLog)
+			sut := WithFields(WithFields(rec, map[string]interface{}{"a": 1, "b": 1}), map[string]interface{}{"b": 2})
+
+			sut.Warn(source, "msg", map[string]interface{}{"c": 3})
+
+			Expect(rec.Entries()).To(HaveLen(1))
+			Expect(rec.Entries()[0].data).To(Equal([]map[string]interface{}{
+				{"a": 1, "b": 2},
+				{"c": 3},
+			}))
+		})
+
+		It("should scope log to source", func() {
+			rec := new(recordingLog)
+			scoped := NewScopedLog(rec, source).With(map[string]interface{}{"a": 1})
+			child := scoped.Scope(source.ChildSystem("child"))
+
+			scoped.Debug("parent")
+			child.Error("child", errors.New("boom"))
+
+			entries := rec.Entries()
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].source).To(Equal(source))
+			Expect(entries[0].data).To(ContainElement(HaveKeyWithValue("a", 1)))
+			Expect(entries[1].source).To(Equal(source.ChildSystem("child")))
+			Expect(entries[1].data).To(BeEmpty())
+			Expect(entries[1].err).To(MatchError("boom"))
+		})
+	})
+
+	Describe("adapters", func() {
+
+		It("should write standard logger lines to log", func() {
+			rec := new(recordingLog)
+			sut := NewStdLogger(rec, source, LevelWarn)
+
+			sut.Printf("hello %s", "world")
+
+			Expect(rec.Entries()).To(HaveLen(1))
+			Expect(rec.Entries()[0].level).To(Equal(LevelWarn))
+			Expect(rec.Entries()[0].msg).To(Equal("hello world"))
+		})
+
+		It("should write to standard logger", func() {
+			buf := new(bytes.Buffer)
+			sut := StdLog{Logger: stdlog.New(buf, "", 0)}
+
+			sut.Error(source, "msg", errors.New("boom"), map[string]interface{}{"b": 2, "a": 1})
+
+			Expect(buf.String()).To(Equal(fmt.Sprintf("ERR %s - \"msg\": boom a=1 b=2\n", source)))
+		})
+
+		It("should round trip through key value logger", func() {
+			rec := new(recordingLog)
+			kv := NewKeyValueLogger(rec, source)
+			sut := KeyValueLog{Logger: kv}
+
+			sut.Error(source, "msg", errors.New("boom"), map[string]interface{}{"a": 1})
+
+			Expect(rec.Entries()).To(HaveLen(1))
+			entry := rec.Entries()[0]
+			Expect(entry.level).To(Equal(LevelError))
+			Expect(entry.msg).To(Equal("msg"))
+			Expect(entry.err).To(MatchError("boom"))
+			Expect(entry.data).To(ContainElement(Equal(map[string]interface{}{"a": 1, "nrn": source.String()})))
+		})
+	})
+})
@@ -0,0 +1,209 @@
+package beacon
+
+import (
+	"fmt"
+	stdlog "log"
+	"sort"
+	"strings"
+)
+
+// NewStdLogger returns a *log.Logger which writes each line it is given
+// to log as a message from source at the given level. This allows beacon
+// messages to be included in code which only accepts a standard logger.
+func NewStdLogger(log Log, source NRN, level Level) *stdlog.Logger {
+	return stdlog.New(stdLogWriter{log: log, source: source, level: level}, "", 0)
+}
+
+type stdLogWriter struct {
+	log    Log
+	source NRN
+	level  Level
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	switch {
+	case w.level >= LevelError:
+		w.log.Error(w.source, msg, nil)
+	case w.level == LevelWarn:
+		w.log.Warn(w.source, msg)
+	default:
+		w.log.Debug(w.source, msg)
+	}
+	return len(p), nil
+}
+
+// StdLog is a Log which writes to a standard library *log.Logger,
+// formatting data as sorted key=value pairs.
+type StdLog struct {
+	Logger *stdlog.Logger
+}
+
+func (s StdLog) Debug(source NRN, msg string, data ...map[string]interface{}) {
+	s.Logger.Print(formatStdLog("DBG", source, msg, nil, data))
+}
+func (s StdLog) Warn(source NRN, msg string, data ...map[string]interface{}) {
+	s.Logger.Print(formatStdLog("WRN", source, msg, nil, data))
+}
+func (s StdLog) Error(source NRN, msg string, err error, data ...map[string]interface{}) {
+	s.Logger.Print(formatStdLog("ERR", source, msg, err, data))
+}
+
+func formatStdLog(prefix string, source NRN, msg string, err error, data []map[string]interface{}) string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "%s %s - %q", prefix, source, msg)
+	if err != nil {
+		fmt.Fprintf(b, ": %s", err)
+	}
+	for _, kv := range sortedLogData(data) {
+		fmt.Fprintf(b, " %s=%+v", kv[0], kv[1])
+	}
+	return b.String()
+}
+
+// KeyValueLogger is the interface of structured loggers which accept
+// alternating keys and values, such as the go-kit logger.
+type KeyValueLogger interface {
+	Log(keyvals ...interface{}) error
+}
+
+// KeyValueLog is a Log which writes to a KeyValueLogger. Each message is
+// written with "level", "nrn" and "msg" keys, an "err" key if there was an
+// error, and the data keys in sorted order.
+type KeyValueLog struct {
+	Logger KeyValueLogger
+}
+
+func (k KeyValueLog) Debug(source NRN, msg string, data ...map[string]interface{}) {
+	k.Logger.Log(keyValues(LevelDebug, source, msg, nil, data)...)
+}
+func (k KeyValueLog) Warn(source NRN, msg string, data ...map[string]interface{}) {
+	k.Logger.Log(keyValues(LevelWarn, source, msg, nil, data)...)
+}
+func (k KeyValueLog) Error(source NRN, msg string, err error, data ...map[string]interface{}) {
+	k.Logger.Log(keyValues(LevelError, source, msg, err, data)...)
+}
+
+func keyValues(level Level, source NRN, msg string, err error, data []map[string]interface{}) []interface{} {
+	keyvals := []interface{}{"level", level.String(), "nrn", source.String(), "msg", msg}
+	if err != nil {
+		keyvals = append(keyvals, "err", err.Error())
+	}
+	for _, kv := range sortedLogData(data) {
+		keyvals = append(keyvals, kv[0], kv[1])
+	}
+	return keyvals
+}
+
+// NewKeyValueLogger returns a KeyValueLogger which writes to log as source.
+// The "level" key selects the Log method (defaulting to Debug), the "msg"
+// or "message" key becomes the message, the "err" or "error" key becomes
+// the error, and all other pairs are passed as data.
+func NewKeyValueLogger(log Log, source NRN) KeyValueLogger {
+	return keyValueLogger{log: log, source: source}
+}
+
+type keyValueLogger struct {
+	log    Log
+	source NRN
+}
+
+func (k keyValueLogger) Log(keyvals ...interface{}) error {
+	var (
+		level = LevelDebug
+		msg   string
+		err   error
+		data  = make(map[string]interface{})
+	)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		var value interface{}
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		switch key {
+		case "level":
+			if l, e := ParseLevel(fmt.Sprint(value)); e == nil {
+				level = l
+			}
+		case "msg", "message":
+			msg = fmt.Sprint(value)
+		case "err", "error":
+			if e, ok := value.(error); ok {
+				err = e
+			} else if value != nil {
+				err = fmt.Errorf("%v", value)
+			}
+		default:
+			data[key] = value
+		}
+	}
+
+	switch level {
+	case LevelError:
+		k.log.Error(k.source, msg, err, data)
+	case LevelWarn:
+		if err != nil {
+			data["error"] = err.Error()
+		}
+		k.log.Warn(k.source, msg, data)
+	default:
+		if err != nil {
+			data["error"] = err.Error()
+		}
+		k.log.Debug(k.source, msg, data)
+	}
+	return nil
+}
+
+// SugaredLogger is the interface of structured loggers which accept
+// a message followed by alternating keys and values, such as the
+// zap SugaredLogger.
+type SugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// SugaredLog is a Log which writes to a SugaredLogger. Each message is
+// written with an "nrn" key, an "error" key if there was an error, and
+// the data keys in sorted order.
+type SugaredLog struct {
+	Logger SugaredLogger
+}
+
+func (s SugaredLog) Debug(source NRN, msg string, data ...map[string]interface{}) {
+	s.Logger.Debugw(msg, sugaredKeyValues(source, nil, data)...)
+}
+func (s SugaredLog) Warn(source NRN, msg string, data ...map[string]interface{}) {
+	s.Logger.Warnw(msg, sugaredKeyValues(source, nil, data)...)
+}
+func (s SugaredLog) Error(source NRN, msg string, err error, data ...map[string]interface{}) {
+	s.Logger.Errorw(msg, sugaredKeyValues(source, err, data)...)
+}
+
+func sugaredKeyValues(source NRN, err error, data []map[string]interface{}) []interface{} {
+	keyvals := []interface{}{"nrn", source.String()}
+	if err != nil {
+		keyvals = append(keyvals, "error", err.Error())
+	}
+	for _, kv := range sortedLogData(data) {
+		keyvals = append(keyvals, kv[0], kv[1])
+	}
+	return keyvals
+}
+
+// sortedLogData merges data and returns it as key/value pairs sorted by key.
+func sortedLogData(data []map[string]interface{}) [][2]interface{} {
+	merged := mergeLogData(data)
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([][2]interface{}, len(keys))
+	for i, k := range keys {
+		pairs[i] = [2]interface{}{k, merged[k]}
+	}
+	return pairs
+}
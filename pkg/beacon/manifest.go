@@ -0,0 +1,466 @@
+package beacon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// ManifestOwnerLabel is the label which marks features and feature instances
+// as managed by a manifest. Its value is the manifest's Owner.
+const ManifestOwnerLabel = "beacon.naveego.com/managed-by"
+
+// Manifest declares features and feature instances, so that they can be
+// kept in version control and synchronized with PlanManifest and ApplyManifest.
+type Manifest struct {
+	// Owner identifies the manifest in the ManifestOwnerLabel of the
+	// resources it manages. Only resources with this label are updated or
+	// pruned.
+	Owner     string                  `json:"owner"`
+	Features  []Feature               `json:"features,omitempty"`
+	Instances []FeatureInstanceInputs `json:"instances,omitempty"`
+}
+
+// ParseManifest parses a JSON, YAML or TOML manifest, detected as
+// described by DetectDocumentFormat.
+func ParseManifest(name string, data []byte) (Manifest, error) {
+	var m Manifest
+	if err := decodeDocument(name, data, &m); err != nil {
+		return m, fmt.Errorf("error deserializing manifest: %s", err)
+	}
+	return m, m.Validate()
+}
+
+// LoadManifest reads and parses the manifest at path.
+func LoadManifest(path string) (Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("error reading manifest: %s", err)
+	}
+	return ParseManifest(path, data)
+}
+
+// Validate checks that the manifest has an owner and that its resources
+// are named and unique.
+func (m Manifest) Validate() error {
+	if m.Owner == "" {
+		return fmt.Errorf("invalid manifest: owner is required")
+	}
+	seen := make(map[string]bool)
+	for i, f := range m.Features {
+		if to.String(f.Name) == "" || to.String(f.Version) == "" {
+			return fmt.Errorf("invalid manifest: feature %d must have a name and version", i)
+		}
+		name := featureManifestName(f)
+		if seen[name] {
+			return fmt.Errorf("invalid manifest: feature %s is declared more than once", name)
+		}
+		seen[name] = true
+	}
+	for i, fi := range m.Instances {
+		if to.String(fi.FeatureName) == "" || to.String(fi.FeatureVersion) == "" || to.String(fi.InstanceName) == "" {
+			return fmt.Errorf("invalid manifest: instance %d must have a featureName, featureVersion and instanceName", i)
+		}
+		name := instanceManifestName(to.String(fi.FeatureName), to.String(fi.FeatureVersion), to.String(fi.InstanceName))
+		if seen[name] {
+			return fmt.Errorf("invalid manifest: instance %s is declared more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// ManifestAction is the type of change a ManifestChange makes.
+type ManifestAction string
+
+// Types of ManifestChange.
+const (
+	ManifestCreate ManifestAction = "create"
+	ManifestUpdate ManifestAction = "update"
+	ManifestDelete ManifestAction = "delete"
+)
+
+// Kinds of resource changed by a ManifestChange.
+const (
+	ManifestFeature  = "feature"
+	ManifestInstance = "instance"
+)
+
+// ManifestChange is a change needed to converge the server with a manifest.
+type ManifestChange struct {
+	Action ManifestAction
+	// Kind is ManifestFeature or ManifestInstance.
+	Kind string
+	// Name identifies the resource, like "orders@1.0.0" for a feature or
+	// "orders@1.0.0/east" for an instance.
+	Name string
+	// Paths are the dot-delimited paths of the fields an update changes.
+	Paths []string
+	// Unsupported explains why the change cannot be applied, if it can't.
+	Unsupported string
+
+	feature  *Feature
+	instance *FeatureInstanceInputs
+	existing *FeatureInstance
+}
+
+// ManifestPlanOptions control PlanManifest.
+type ManifestPlanOptions struct {
+	// Prune deletes resources labelled with the manifest's Owner which are
+	// no longer in the manifest.
+	Prune bool
+	// Recreate updates instances by deleting them and creating them again,
+	// as the API cannot update instances. This unprovisions and provisions
+	// them, and they don't exist in between. Without it, instance updates
+	// are unsupported.
+	Recreate bool
+}
+
+// ManifestPlan contains the changes needed to converge the server with a manifest.
+type ManifestPlan struct {
+	Owner string
+	// Changes are ordered features first, then instances, each sorted by name.
+	Changes []ManifestChange
+}
+
+// HasChanges returns true if the plan contains any changes.
+func (p *ManifestPlan) HasChanges() bool {
+	return len(p.Changes) > 0
+}
+
+// Unsupported returns the changes which cannot be applied.
+func (p *ManifestPlan) Unsupported() []ManifestChange {
+	var out []ManifestChange
+	for _, c := range p.Changes {
+		if c.Unsupported != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// String returns a human-readable description of the plan.
+func (p *ManifestPlan) String() string {
+	var buf bytes.Buffer
+	counts := make(map[ManifestAction]int)
+	unsupported := 0
+	for _, c := range p.Changes {
+		symbol := map[ManifestAction]string{ManifestCreate: "+", ManifestUpdate: "~", ManifestDelete: "-"}[c.Action]
+		if c.Unsupported != "" {
+			symbol = "!"
+			unsupported++
+		} else {
+			counts[c.Action]++
+		}
+		fmt.Fprintf(&buf, "%s %s %s %s", symbol, c.Action, c.Kind, c.Name)
+		if len(c.Paths) > 0 {
+			fmt.Fprintf(&buf, " (%s)", strings.Join(c.Paths, ", "))
+		}
+		if c.Unsupported != "" {
+			fmt.Fprintf(&buf, ": %s", c.Unsupported)
+		}
+		buf.WriteString("\n")
+	}
+	fmt.Fprintf(&buf, "Plan: %d to create, %d to update, %d to delete, %d unsupported.\n",
+		counts[ManifestCreate], counts[ManifestUpdate], counts[ManifestDelete], unsupported)
+	return buf.String()
+}
+
+// PlanManifest compares the manifest with the features and feature instances
+// on the server, and returns the changes needed to converge them.
+//
+// Only the fields set in the manifest are compared, so fields and labels
+// added on the server are ignored. The API cannot update or delete features,
+// so changes to existing feature versions are unsupported; publish a new
+// version instead. It cannot update feature instances either, so changes to
+// them are unsupported unless options.Recreate is set, when they are deleted
+// and re-created with the same key, and disabled again if they were disabled.
+// Their config is validated against the feature's schema before they are
+// deleted, and if re-creating one fails the deleted instance is restored.
+// Resources which exist but are not labelled with the manifest's Owner are
+// never changed.
+func (client BaseClient) PlanManifest(ctx context.Context, m Manifest, options ManifestPlanOptions) (*ManifestPlan, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	features, err := client.GetFeatures(ctx, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("error getting features: %s", err)
+	}
+	instances, err := client.GetFeatureInstances(ctx, "", "", "", "", "")
+	if err != nil {
+		return nil, fmt.Errorf("error getting feature instances: %s", err)
+	}
+
+	plan := &ManifestPlan{Owner: m.Owner}
+	plan.Changes = append(plan.Changes, planManifestFeatures(m, features.Value, options)...)
+	plan.Changes = append(plan.Changes, planManifestInstances(m, instances.Value, options)...)
+	return plan, nil
+}
+
+func planManifestFeatures(m Manifest, features *[]Feature, options ManifestPlanOptions) []ManifestChange {
+	existing := make(map[string]Feature)
+	if features != nil {
+		for _, f := range *features {
+			existing[featureManifestName(f)] = f
+		}
+	}
+
+	var changes []ManifestChange
+	declared := make(map[string]bool)
+	for i := range m.Features {
+		f := m.Features[i]
+		f.Labels = ownerLabels(f.Labels, m.Owner)
+		name := featureManifestName(f)
+		declared[name] = true
+
+		current, ok := existing[name]
+		if !ok {
+			changes = append(changes, ManifestChange{Action: ManifestCreate, Kind: ManifestFeature, Name: name, feature: &f})
+			continue
+		}
+		if paths := manifestDiff(current, f); len(paths) > 0 {
+			reason := "the API cannot update features; publish a new version instead"
+			if !ownedBy(current.Labels, m.Owner) {
+				reason = notOwned(m.Owner)
+			}
+			changes = append(changes, ManifestChange{Action: ManifestUpdate, Kind: ManifestFeature, Name: name, Paths: paths, Unsupported: reason})
+		}
+	}
+
+	if options.Prune {
+		for name, f := range existing {
+			if !declared[name] && ownedBy(f.Labels, m.Owner) {
+				changes = append(changes, ManifestChange{Action: ManifestDelete, Kind: ManifestFeature, Name: name, Unsupported: "the API cannot delete features"})
+			}
+		}
+	}
+	sortManifestChanges(changes)
+	return changes
+}
+
+func planManifestInstances(m Manifest, instances *[]FeatureInstance, options ManifestPlanOptions) []ManifestChange {
+	existing := make(map[string]FeatureInstance)
+	if instances != nil {
+		for _, fi := range *instances {
+			existing[instanceManifestName(to.String(fi.FeatureName), to.String(fi.FeatureVersion), to.String(fi.InstanceName))] = fi
+		}
+	}
+
+	var changes []ManifestChange
+	declared := make(map[string]bool)
+	for i := range m.Instances {
+		fi := m.Instances[i]
+		fi.Labels = ownerLabels(fi.Labels, m.Owner)
+		name := instanceManifestName(to.String(fi.FeatureName), to.String(fi.FeatureVersion), to.String(fi.InstanceName))
+		declared[name] = true
+
+		current, ok := existing[name]
+		if !ok {
+			changes = append(changes, ManifestChange{Action: ManifestCreate, Kind: ManifestInstance, Name: name, instance: &fi})
+			continue
+		}
+		if paths := manifestDiff(current, fi); len(paths) > 0 {
+			change := ManifestChange{Action: ManifestUpdate, Kind: ManifestInstance, Name: name, Paths: paths, instance: &fi, existing: &current}
+			switch {
+			case !ownedBy(current.Labels, m.Owner):
+				change.Unsupported = notOwned(m.Owner)
+			case !options.Recreate:
+				change.Unsupported = "the API cannot update instances; plan with Recreate to delete and re-create it"
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	if options.Prune {
+		for name := range existing {
+			current := existing[name]
+			if !declared[name] && ownedBy(current.Labels, m.Owner) {
+				changes = append(changes, ManifestChange{Action: ManifestDelete, Kind: ManifestInstance, Name: name, existing: &current})
+			}
+		}
+	}
+	sortManifestChanges(changes)
+	return changes
+}
+
+// ApplyManifest makes the changes in plan, in order. If the plan contains
+// unsupported changes it returns an error without making any changes.
+func (client BaseClient) ApplyManifest(ctx context.Context, plan *ManifestPlan) error {
+	if unsupported := plan.Unsupported(); len(unsupported) > 0 {
+		var reasons []string
+		for _, c := range unsupported {
+			reasons = append(reasons, fmt.Sprintf("%s %s %s: %s", c.Action, c.Kind, c.Name, c.Unsupported))
+		}
+		return fmt.Errorf("manifest plan contains unsupported changes: %s", strings.Join(reasons, "; "))
+	}
+
+	for _, c := range plan.Changes {
+		if err := client.applyManifestChange(ctx, c); err != nil {
+			return fmt.Errorf("error applying %s of %s %s: %s", c.Action, c.Kind, c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (client BaseClient) applyManifestChange(ctx context.Context, c ManifestChange) error {
+	if c.Kind == ManifestFeature {
+		_, err := client.CreateFeature(ctx, c.feature)
+		return err
+	}
+
+	if c.existing != nil {
+		if c.Action == ManifestUpdate {
+			// Check that the instance can be re-created before deleting it.
+			if err := client.validateManifestInstance(ctx, *c.instance); err != nil {
+				return err
+			}
+		}
+		_, err := client.DeleteFeatureInstance(ctx, to.String(c.existing.FeatureName), to.String(c.existing.FeatureVersion), to.String(c.existing.InstanceName))
+		if err != nil || c.Action == ManifestDelete {
+			return err
+		}
+	}
+
+	inputs := *c.instance
+	if inputs.Key == nil && c.existing != nil {
+		// Keep the key, so that systems can still retrieve the instance.
+		inputs.Key = c.existing.Key
+	}
+	_, err := client.CreateFeatureInstance(ctx, &inputs)
+	if c.existing == nil {
+		return err
+	}
+	if err == nil {
+		return client.keepManifestInstanceDisabled(ctx, *c.existing)
+	}
+
+	// The instance was deleted to update it, so put it back as it was.
+	previous := FeatureInstanceInputs{
+		FeatureName:           c.existing.FeatureName,
+		FeatureVersion:        c.existing.FeatureVersion,
+		InstanceName:          c.existing.InstanceName,
+		Labels:                c.existing.Labels,
+		Tenant:                c.existing.Tenant,
+		Config:                c.existing.Config,
+		Key:                   c.existing.Key,
+		ProvisionerParameters: c.existing.ProvisionerParameters,
+	}
+	if _, restoreErr := client.CreateFeatureInstance(ctx, &previous); restoreErr != nil {
+		return fmt.Errorf("%s, and the deleted instance could not be restored: %s", err, restoreErr)
+	}
+	if disableErr := client.keepManifestInstanceDisabled(ctx, *c.existing); disableErr != nil {
+		return fmt.Errorf("%s; the previous instance was restored, but %s", err, disableErr)
+	}
+	return fmt.Errorf("%s; the previous instance was restored", err)
+}
+
+// keepManifestInstanceDisabled disables the instance re-created in place of
+// existing if existing was disabled, since instances are created enabled.
+func (client BaseClient) keepManifestInstanceDisabled(ctx context.Context, existing FeatureInstance) error {
+	if existing.Enabled() {
+		return nil
+	}
+	_, err := client.DisableFeatureInstance(ctx, to.String(existing.FeatureName), to.String(existing.FeatureVersion), to.String(existing.InstanceName))
+	if err != nil {
+		return fmt.Errorf("the re-created instance could not be disabled: %s", err)
+	}
+	return nil
+}
+
+// validateManifestInstance validates the config of inputs against the
+// InstanceConfigSchema of its feature.
+func (client BaseClient) validateManifestInstance(ctx context.Context, inputs FeatureInstanceInputs) error {
+	feature, err := client.GetFeature(ctx, to.String(inputs.FeatureName), to.String(inputs.FeatureVersion))
+	if err != nil {
+		return err
+	}
+	return feature.ValidateConfig(inputs.Config)
+}
+
+// manifestDiff returns the paths of the fields set in desired which differ
+// in current. Keys which are only set in current are ignored, including
+// those of nested objects, so that labels and other fields added on the
+// server don't make a resource differ from its manifest.
+func manifestDiff(current, desired interface{}) []string {
+	currentDoc, _ := normalizeJSON(current, false)
+	desiredDoc, _ := normalizeJSON(desired, false)
+	paths := diffSetJSON(currentDoc, desiredDoc, "", nil)
+	sort.Strings(paths)
+	return paths
+}
+
+// diffSetJSON appends the paths of the values set in desired which differ
+// in current to paths. Objects are compared by the keys set in desired, and
+// arrays of the same length element by element; an array which differs is
+// reported by its own path.
+func diffSetJSON(current, desired interface{}, path string, paths []string) []string {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		c, ok := current.(map[string]interface{})
+		if !ok {
+			return append(paths, path)
+		}
+		for k, v := range d {
+			paths = diffSetJSON(c[k], v, joinJSONPath(path, k), paths)
+		}
+		return paths
+	case []interface{}:
+		c, ok := current.([]interface{})
+		if !ok || len(c) != len(d) {
+			return append(paths, path)
+		}
+		for i := range d {
+			if len(diffSetJSON(c[i], d[i], path, nil)) > 0 {
+				return append(paths, path)
+			}
+		}
+		return paths
+	}
+	if !reflect.DeepEqual(current, desired) {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// ownerLabels returns a copy of labels with ManifestOwnerLabel set to owner.
+func ownerLabels(labels interface{}, owner string) interface{} {
+	out := map[string]interface{}{}
+	if m, ok := labels.(map[string]interface{}); ok {
+		for k, v := range m {
+			out[k] = v
+		}
+	}
+	out[ManifestOwnerLabel] = owner
+	return out
+}
+
+func ownedBy(labels interface{}, owner string) bool {
+	m, ok := labels.(map[string]interface{})
+	return ok && m[ManifestOwnerLabel] == owner
+}
+
+func notOwned(owner string) string {
+	return fmt.Sprintf("it exists but is not labelled %s=%s", ManifestOwnerLabel, owner)
+}
+
+func featureManifestName(f Feature) string {
+	return to.String(f.Name) + "@" + to.String(f.Version)
+}
+
+func instanceManifestName(featureName, featureVersion, instanceName string) string {
+	return featureName + "@" + featureVersion + "/" + instanceName
+}
+
+func sortManifestChanges(changes []ManifestChange) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+}
@@ -0,0 +1,298 @@
+package beacon_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("Manifest", func() {
+
+	var (
+		mu        sync.Mutex
+		features  []map[string]interface{}
+		instances []map[string]interface{}
+		requests  []string
+		// failCreates is the number of instance creations to fail.
+		failCreates int
+		server      *httptest.Server
+		client      BaseClient
+	)
+
+	owned := map[string]interface{}{ManifestOwnerLabel: "orders-service"}
+
+	BeforeEach(func() {
+		requests = nil
+		failCreates = 0
+		features = []map[string]interface{}{
+			{"name": "orders", "version": "1.0.0", "path": "nrn:beacon::ftr:orders:1.0.0", "labels": owned, "systemStartupMS": 1000},
+			{"name": "orders", "version": "0.9.0", "labels": owned},
+			{"name": "billing", "version": "1.0.0"},
+		}
+		instances = []map[string]interface{}{
+			{"featureName": "orders", "featureVersion": "1.0.0", "instanceName": "east", "key": "k-east", "labels": owned, "config": map[string]interface{}{"region": "us-east-1"}},
+			{"featureName": "orders", "featureVersion": "1.0.0", "instanceName": "old", "labels": owned},
+			{"featureName": "billing", "featureVersion": "1.0.0", "instanceName": "main", "config": map[string]interface{}{"a": 1}},
+		}
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Method != http.MethodGet {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+			}
+
+			var body map[string]interface{}
+			if r.Method == http.MethodPost && !strings.HasSuffix(r.URL.Path, "/actions/disable") {
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+			}
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/api/features":
+				json.NewEncoder(w).Encode(features)
+			case r.Method == http.MethodGet && r.URL.Path == "/api/features/instances":
+				json.NewEncoder(w).Encode(instances)
+			case r.Method == http.MethodPost && r.URL.Path == "/api/features":
+				features = append(features, body)
+				json.NewEncoder(w).Encode(body)
+			case r.Method == http.MethodPost && r.URL.Path == "/api/features/instances" && failCreates > 0:
+				failCreates--
+				w.WriteHeader(http.StatusBadRequest)
+			case r.Method == http.MethodPost && r.URL.Path == "/api/features/instances":
+				instances = append(instances, body)
+				json.NewEncoder(w).Encode(body)
+			case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/actions/disable"):
+				parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/features/instances/"), "/")
+				for _, fi := range instances {
+					if fi["featureName"] == parts[0] && fi["featureVersion"] == parts[1] && fi["instanceName"] == parts[2] {
+						fi["isEnabled"] = false
+						json.NewEncoder(w).Encode(fi)
+						return
+					}
+				}
+				w.WriteHeader(http.StatusNotFound)
+			case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/features/instances/"):
+				parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/features/instances/"), "/")
+				for i, fi := range instances {
+					if fi["featureName"] == parts[0] && fi["featureVersion"] == parts[1] && fi["instanceName"] == parts[2] {
+						json.NewEncoder(w).Encode(fi)
+						instances = append(instances[:i], instances[i+1:]...)
+						return
+					}
+				}
+				w.WriteHeader(http.StatusNotFound)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		client = NewWithBaseURI(server.URL)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	manifest := func(region string) Manifest {
+		m, err := ParseManifest("manifest.yaml", []byte(fmt.Sprintf(`
+owner: orders-service
+features:
+  - name: orders
+    version: 1.0.0
+    systemStartupMS: 1000
+  - name: orders
+    version: 2.0.0
+    healthchecks:
+      - name: heartbeat
+        type: heartbeat
+        intervalMS: 1000
+    instanceConfigSchema:
+      type: object
+    provisioningTasks:
+      - taskName: create-database
+        values: {name: orders}
+instances:
+  - featureName: orders
+    featureVersion: 1.0.0
+    instanceName: east
+    config:
+      region: %s
+  - featureName: orders
+    featureVersion: 2.0.0
+    instanceName: west
+`, region)))
+		Expect(err).ToNot(HaveOccurred())
+		return m
+	}
+
+	It("should plan and apply the changes needed to converge", func() {
+		plan, err := client.PlanManifest(context.Background(), manifest("eu-west-1"), ManifestPlanOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.String()).To(ContainSubstring("! update instance orders@1.0.0/east (config.region): the API cannot update instances; plan with Recreate to delete and re-create it\n"))
+		Expect(client.ApplyManifest(context.Background(), plan)).To(MatchError(ContainSubstring("unsupported changes: update instance orders@1.0.0/east")))
+		Expect(requests).To(BeEmpty())
+
+		plan, err = client.PlanManifest(context.Background(), manifest("eu-west-1"), ManifestPlanOptions{Recreate: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.String()).To(Equal("" +
+			"+ create feature orders@2.0.0\n" +
+			"~ update instance orders@1.0.0/east (config.region)\n" +
+			"+ create instance orders@2.0.0/west\n" +
+			"Plan: 2 to create, 1 to update, 0 to delete, 0 unsupported.\n"))
+
+		Expect(client.ApplyManifest(context.Background(), plan)).To(Succeed())
+		Expect(requests).To(Equal([]string{
+			"POST /api/features",
+			"DELETE /api/features/instances/orders/1.0.0/east",
+			"POST /api/features/instances",
+			"POST /api/features/instances",
+		}))
+		created := features[len(features)-1]
+		Expect(created).To(HaveKeyWithValue("labels", owned))
+		Expect(created).To(HaveKey("provisioningTasks"))
+		east := instances[len(instances)-2]
+		Expect(east).To(HaveKeyWithValue("key", "k-east"))
+		Expect(east).To(HaveKeyWithValue("config", map[string]interface{}{"region": "eu-west-1"}))
+
+		plan, err = client.PlanManifest(context.Background(), manifest("eu-west-1"), ManifestPlanOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.HasChanges()).To(BeFalse())
+	})
+
+	It("should only prune owned resources when asked to", func() {
+		plan, err := client.PlanManifest(context.Background(), manifest("us-east-1"), ManifestPlanOptions{Prune: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.String()).To(Equal("" +
+			"! delete feature orders@0.9.0: the API cannot delete features\n" +
+			"+ create feature orders@2.0.0\n" +
+			"- delete instance orders@1.0.0/old\n" +
+			"+ create instance orders@2.0.0/west\n" +
+			"Plan: 2 to create, 0 to update, 1 to delete, 1 unsupported.\n"))
+
+		Expect(client.ApplyManifest(context.Background(), plan)).To(MatchError(ContainSubstring("unsupported changes: delete feature orders@0.9.0")))
+		Expect(requests).To(BeEmpty())
+	})
+
+	It("should not change existing feature versions or unowned resources", func() {
+		m := manifest("us-east-1")
+		m.Features[0].SystemStartupMS = to.Float64Ptr(2000)
+		m.Instances = append(m.Instances, FeatureInstanceInputs{
+			FeatureName:    to.StringPtr("billing"),
+			FeatureVersion: to.StringPtr("1.0.0"),
+			InstanceName:   to.StringPtr("main"),
+		})
+
+		plan, err := client.PlanManifest(context.Background(), m, ManifestPlanOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Unsupported()).To(HaveLen(2))
+		Expect(plan.String()).To(ContainSubstring("! update feature orders@1.0.0 (systemStartupMS): the API cannot update features; publish a new version instead\n"))
+		Expect(plan.String()).To(ContainSubstring("! update instance billing@1.0.0/main (labels): it exists but is not labelled"))
+	})
+
+	It("should ignore fields which are only set on the server", func() {
+		instances[0]["labels"] = map[string]interface{}{ManifestOwnerLabel: "orders-service", "team": "fulfilment"}
+		instances[0]["config"] = map[string]interface{}{"region": "us-east-1", "zone": "a"}
+		features[0]["healthchecks"] = []interface{}{map[string]interface{}{"name": "heartbeat", "id": "h1"}}
+		m := manifest("us-east-1")
+		m.Features = m.Features[:1]
+		m.Features[0].Healthchecks = &[]Healthcheck{{Name: to.StringPtr("heartbeat")}}
+		m.Instances = m.Instances[:1]
+
+		plan, err := client.PlanManifest(context.Background(), m, ManifestPlanOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.HasChanges()).To(BeFalse(), plan.String())
+
+		m.Instances[0].Labels = map[string]interface{}{"team": "billing"}
+		plan, err = client.PlanManifest(context.Background(), m, ManifestPlanOptions{Recreate: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.String()).To(HavePrefix("~ update instance orders@1.0.0/east (labels.team)\n"))
+	})
+
+	It("should restore an instance which cannot be re-created", func() {
+		plan, err := client.PlanManifest(context.Background(), manifest("eu-west-1"), ManifestPlanOptions{Recreate: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		failCreates = 1
+		err = client.ApplyManifest(context.Background(), plan)
+		Expect(err).To(MatchError(HavePrefix("error applying update of instance orders@1.0.0/east: ")))
+		Expect(err).To(MatchError(HaveSuffix("; the previous instance was restored")))
+		Expect(requests).To(Equal([]string{
+			"POST /api/features",
+			"DELETE /api/features/instances/orders/1.0.0/east",
+			"POST /api/features/instances",
+			"POST /api/features/instances",
+		}))
+		east := instances[len(instances)-1]
+		Expect(east).To(HaveKeyWithValue("key", "k-east"))
+		Expect(east).To(HaveKeyWithValue("config", map[string]interface{}{"region": "us-east-1"}))
+
+		requests = nil
+		failCreates = 2
+		plan, err = client.PlanManifest(context.Background(), manifest("eu-west-1"), ManifestPlanOptions{Recreate: true})
+		Expect(err).ToNot(HaveOccurred())
+		err = client.ApplyManifest(context.Background(), plan)
+		Expect(err).To(MatchError(ContainSubstring(", and the deleted instance could not be restored: ")))
+	})
+
+	It("should keep a re-created instance disabled", func() {
+		instances[0]["isEnabled"] = false
+		plan, err := client.PlanManifest(context.Background(), manifest("eu-west-1"), ManifestPlanOptions{Recreate: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.ApplyManifest(context.Background(), plan)).To(Succeed())
+		Expect(requests).To(Equal([]string{
+			"POST /api/features",
+			"DELETE /api/features/instances/orders/1.0.0/east",
+			"POST /api/features/instances",
+			"POST /api/features/instances/orders/1.0.0/east/actions/disable",
+			"POST /api/features/instances",
+		}))
+		Expect(instances[len(instances)-2]).To(HaveKeyWithValue("isEnabled", false))
+
+		requests = nil
+		failCreates = 1
+		plan, err = client.PlanManifest(context.Background(), manifest("us-east-1"), ManifestPlanOptions{Recreate: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.ApplyManifest(context.Background(), plan)).To(MatchError(HaveSuffix("; the previous instance was restored")))
+		Expect(requests).To(Equal([]string{
+			"DELETE /api/features/instances/orders/1.0.0/east",
+			"POST /api/features/instances",
+			"POST /api/features/instances",
+			"POST /api/features/instances/orders/1.0.0/east/actions/disable",
+		}))
+		east := instances[len(instances)-1]
+		Expect(east).To(HaveKeyWithValue("config", map[string]interface{}{"region": "eu-west-1"}))
+		Expect(east).To(HaveKeyWithValue("isEnabled", false))
+	})
+
+	It("should validate the config of an instance before deleting it to update it", func() {
+		features[0]["instanceConfigSchema"] = map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"region": map[string]interface{}{"enum": []interface{}{"us-east-1"}}},
+		}
+		plan, err := client.PlanManifest(context.Background(), manifest("eu-west-1"), ManifestPlanOptions{Recreate: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		err = client.ApplyManifest(context.Background(), plan)
+		Expect(err).To(MatchError(HavePrefix("error applying update of instance orders@1.0.0/east: ")))
+		Expect(requests).To(Equal([]string{"POST /api/features"}))
+		Expect(instances[0]).To(HaveKeyWithValue("instanceName", "east"))
+	})
+
+	It("should validate manifests", func() {
+		_, err := ParseManifest("m.json", []byte(`{"features":[]}`))
+		Expect(err).To(MatchError("invalid manifest: owner is required"))
+		_, err = ParseManifest("m.json", []byte(`{"owner":"x","instances":[{"featureName":"a"}]}`))
+		Expect(err).To(MatchError(ContainSubstring("instance 0 must have")))
+		_, err = ParseManifest("m.json", []byte(`{"owner":"x","features":[{"name":"a","version":"1"},{"name":"a","version":"1"}]}`))
+		Expect(err).To(MatchError("invalid manifest: feature a@1 is declared more than once"))
+	})
+})
@@ -0,0 +1,202 @@
+package beacon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Names of the layers merged by NewEffectiveConfig.
+const (
+	ConfigLayerFeature  = "feature"
+	ConfigLayerInstance = "instance"
+)
+
+// ConfigLayer is a config merged by MergeConfig.
+type ConfigLayer struct {
+	// Name identifies the layer in EffectiveConfig.Provenance.
+	Name   string
+	Config interface{}
+}
+
+// EffectiveConfig is the result of merging layers of config.
+type EffectiveConfig struct {
+	Config interface{}
+	// Provenance maps the JSON pointer of each value which is not an object,
+	// like "/db/host", to the name of the layer it came from.
+	Provenance map[string]string
+}
+
+// NewEffectiveConfig merges the feature's Config, which holds feature-wide
+// defaults, then the instance's Config, then overrides, as described by MergeConfig.
+func NewEffectiveConfig(feature Feature, instance FeatureInstance, overrides ...ConfigLayer) (EffectiveConfig, error) {
+	layers := append([]ConfigLayer{
+		{Name: ConfigLayerFeature, Config: feature.Config},
+		{Name: ConfigLayerInstance, Config: instance.Config},
+	}, overrides...)
+	return MergeConfig(layers...)
+}
+
+// MergeConfig deep-merges layers of config, with later layers taking
+// precedence. Objects are merged key by key; any other value, including an
+// array, replaces the value from earlier layers as a whole. A null value
+// removes the value from earlier layers. Encrypted values, see Keyring, are
+// not objects and so replace values as a whole. Layers whose Config is nil
+// are skipped.
+func MergeConfig(layers ...ConfigLayer) (EffectiveConfig, error) {
+	effective := EffectiveConfig{Provenance: make(map[string]string)}
+	for _, layer := range layers {
+		if layer.Config == nil {
+			continue
+		}
+		config, err := normalizeJSON(layer.Config, false)
+		if err != nil {
+			return EffectiveConfig{}, fmt.Errorf("error merging %s config: %s", layer.Name, err)
+		}
+		effective.Config = effective.merge(effective.Config, config, "", layer.Name)
+	}
+	return effective, nil
+}
+
+func (e EffectiveConfig) merge(base, overlay interface{}, path string, layer string) interface{} {
+	overlayMap, ok := overlay.(map[string]interface{})
+	if !ok || isEncryptedValue(overlay) {
+		e.forget(path)
+		if overlay != nil {
+			e.Provenance[path] = layer
+		}
+		return overlay
+	}
+
+	baseMap, ok := base.(map[string]interface{})
+	if !ok || isEncryptedValue(base) {
+		e.forget(path)
+		baseMap = nil
+	}
+	out := make(map[string]interface{}, len(baseMap)+len(overlayMap))
+	for k, v := range baseMap {
+		out[k] = v
+	}
+	for k, v := range overlayMap {
+		childPath := path + "/" + escapePointer(k)
+		if v == nil {
+			e.forget(childPath)
+			delete(out, k)
+			continue
+		}
+		out[k] = e.merge(out[k], v, childPath, layer)
+	}
+	return out
+}
+
+// forget removes the provenance of path and everything beneath it.
+func (e EffectiveConfig) forget(path string) {
+	for p := range e.Provenance {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(e.Provenance, p)
+		}
+	}
+}
+
+// Source returns the name of the layer the value at the JSON pointer path
+// came from, or an empty string if there is no such value or it is an object.
+// Paths within arrays return the layer of the array.
+func (e EffectiveConfig) Source(path string) string {
+	for p := path; ; {
+		if layer, ok := e.Provenance[p]; ok {
+			return layer
+		}
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			return ""
+		}
+		p = p[:i]
+	}
+}
+
+// Report returns a table of each value's path and the layer it came from,
+// sorted by path.
+func (e EffectiveConfig) Report() string {
+	paths := make([]string, 0, len(e.Provenance))
+	for p := range e.Provenance {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tLAYER")
+	for _, p := range paths {
+		display := p
+		if display == "" {
+			display = "/"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", display, e.Provenance[p])
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// ExtractConfig unmarshalls Config into `to`, like FeatureInstance.ExtractConfig.
+func (e EffectiveConfig) ExtractConfig(to interface{}) error {
+	return FeatureInstance{Config: e.Config}.ExtractConfig(to)
+}
+
+// EnvConfigOverrides returns a layer named "env" built from the environment
+// variables whose names start with prefix followed by "__". The rest of the
+// name is the path of the value, with "__" separating keys, so that with the
+// prefix "APP_CONFIG" the variable APP_CONFIG__db__maxConns=10 sets
+// {"db": {"maxConns": 10}}. Values which are valid JSON are parsed as JSON,
+// and other values are strings.
+func EnvConfigOverrides(prefix string) ConfigLayer {
+	config := make(map[string]interface{})
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix+"__") {
+			continue
+		}
+		keys := strings.Split(strings.TrimPrefix(parts[0], prefix+"__"), "__")
+
+		var value interface{}
+		if err := json.Unmarshal([]byte(parts[1]), &value); err != nil {
+			value = parts[1]
+		}
+
+		m := config
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := m[key].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				m[key] = child
+			}
+			m = child
+		}
+		m[keys[len(keys)-1]] = value
+	}
+	if len(config) == 0 {
+		return ConfigLayer{Name: "env"}
+	}
+	return ConfigLayer{Name: "env", Config: config}
+}
+
+// FileConfigOverrides returns a layer named after path containing the
+// JSON, YAML or TOML document in the file. If the file does not exist the layer is empty.
+func FileConfigOverrides(path string) (ConfigLayer, error) {
+	layer := ConfigLayer{Name: "file:" + path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return layer, nil
+	}
+	if err != nil {
+		return layer, fmt.Errorf("error reading config overrides: %s", err)
+	}
+	if layer.Config, err = DecodeDocument(path, b); err != nil {
+		return layer, fmt.Errorf("error deserializing config overrides %q: %s", path, err)
+	}
+	return layer, nil
+}
@@ -0,0 +1,145 @@
+package beacon_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("EffectiveConfig", func() {
+
+	feature := Feature{Config: map[string]interface{}{
+		"db":    map[string]interface{}{"host": "localhost", "port": 5432, "options": map[string]interface{}{"ssl": true}},
+		"hosts": []interface{}{"a", "b"},
+		"debug": false,
+	}}
+
+	It("should deep-merge feature, instance and override config", func() {
+		instance := FeatureInstance{Config: map[string]interface{}{
+			"db":    map[string]interface{}{"host": "db.example.com", "options": nil},
+			"hosts": []interface{}{"c"},
+		}}
+		effective, err := NewEffectiveConfig(feature, instance, ConfigLayer{
+			Name:   "local",
+			Config: map[string]interface{}{"debug": true},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(effective.Config).To(Equal(map[string]interface{}{
+			"db":    map[string]interface{}{"host": "db.example.com", "port": float64(5432)},
+			"hosts": []interface{}{"c"},
+			"debug": true,
+		}))
+		Expect(effective.Provenance).To(Equal(map[string]string{
+			"/db/host": ConfigLayerInstance,
+			"/db/port": ConfigLayerFeature,
+			"/hosts":   ConfigLayerInstance,
+			"/debug":   "local",
+		}))
+		Expect(effective.Source("/hosts/0")).To(Equal(ConfigLayerInstance))
+		Expect(effective.Source("/db")).To(Equal(""))
+		Expect(effective.Report()).To(Equal("PATH      LAYER\n" +
+			"/db/host  instance\n" +
+			"/db/port  feature\n" +
+			"/debug    local\n" +
+			"/hosts    instance\n"))
+
+		Expect(feature.Config).To(HaveKeyWithValue("db", HaveKey("options")))
+	})
+
+	It("should replace values of a different type", func() {
+		effective, err := MergeConfig(
+			ConfigLayer{Name: "a", Config: map[string]interface{}{"x": map[string]interface{}{"y": 1}}},
+			ConfigLayer{Name: "b", Config: map[string]interface{}{"x": "flat"}},
+			ConfigLayer{Name: "c"},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(effective.Config).To(Equal(map[string]interface{}{"x": "flat"}))
+		Expect(effective.Provenance).To(Equal(map[string]string{"/x": "b"}))
+	})
+
+	It("should load overrides from the environment and files", func() {
+		os.Setenv("BEACON_TEST_CONFIG__db__port", "6543")
+		os.Setenv("BEACON_TEST_CONFIG__db__user", "admin")
+		defer os.Unsetenv("BEACON_TEST_CONFIG__db__port")
+		defer os.Unsetenv("BEACON_TEST_CONFIG__db__user")
+
+		env := EnvConfigOverrides("BEACON_TEST_CONFIG")
+		Expect(env.Name).To(Equal("env"))
+		Expect(env.Config).To(Equal(map[string]interface{}{
+			"db": map[string]interface{}{"port": float64(6543), "user": "admin"},
+		}))
+		Expect(EnvConfigOverrides("BEACON_TEST_NONE").Config).To(BeNil())
+
+		dir, err := ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "overrides.json")
+		Expect(ioutil.WriteFile(path, []byte(`{"debug":true}`), 0600)).To(Succeed())
+		file, err := FileConfigOverrides(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		missing, err := FileConfigOverrides(filepath.Join(dir, "missing.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(missing.Config).To(BeNil())
+
+		instancePath := filepath.Join(dir, "instance.json")
+		writeFeatureInstance(instancePath, `{"config":{"db":{"host":"db.example.com"}}}`)
+		monitor, err := NewFeatureInstanceMonitorWithOptions(NewFileSource(instancePath), MonitorOptions{
+			FeatureConfig:   feature.Config,
+			ConfigOverrides: []ConfigLayer{env, file, missing},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var cfg struct {
+			DB struct {
+				Host string
+				Port int
+				User string
+			}
+			Debug bool
+		}
+		Expect(monitor.ExtractConfig(&cfg)).To(Succeed())
+		Expect(cfg.DB.Host).To(Equal("db.example.com"))
+		Expect(cfg.DB.Port).To(Equal(6543))
+		Expect(cfg.DB.User).To(Equal("admin"))
+		Expect(cfg.Debug).To(BeTrue())
+		Expect(monitor.EffectiveConfig().Source("/debug")).To(Equal("file:" + path))
+		Expect(monitor.EffectiveConfig().Source("/db/options/ssl")).To(Equal(ConfigLayerFeature))
+	})
+
+	It("should not merge defaults into encrypted values", func() {
+		keyring := NewKeyring()
+		Expect(keyring.GenerateKey("k1")).To(Succeed())
+		config, err := keyring.EncryptConfig(map[string]interface{}{
+			"creds": map[string]interface{}{"user": "svc", "password": "s3cret"},
+		}, "/creds")
+		Expect(err).ToNot(HaveOccurred())
+		doc, err := json.Marshal(FeatureInstance{Config: config})
+		Expect(err).ToNot(HaveOccurred())
+
+		dir, err := ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "instance.json")
+		writeFeatureInstance(path, string(doc))
+
+		monitor, err := NewFeatureInstanceMonitorWithOptions(NewFileSource(path), MonitorOptions{
+			Keyring: keyring,
+			FeatureConfig: map[string]interface{}{
+				"creds": map[string]interface{}{"user": "default", "password": "default", "role": "reader"},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(monitor.Config()).To(Equal(map[string]interface{}{
+			"creds": map[string]interface{}{"user": "svc", "password": "s3cret"},
+		}))
+		Expect(monitor.EffectiveConfig().Provenance).To(Equal(map[string]string{"/creds": ConfigLayerInstance}))
+	})
+})
@@ -3,143 +3,597 @@ package beacon
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"math/rand"
+	"reflect"
 	"sync"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
+)
 
-	getter "github.com/hashicorp/go-getter"
+const (
+	// refreshTimeout is the time allowed for a config source to fetch a FeatureInstance.
+	refreshTimeout = 30 * time.Second
+	// defaultMaxBackoff is the default for MonitorOptions.MaxBackoff.
+	defaultMaxBackoff = 5 * time.Minute
 )
 
 // FeatureInstanceMonitor retrieves and monitors a beacon FeatureInstance.
 type FeatureInstanceMonitor struct {
-	ConfigURL          string
+	Source ConfigSource
+
+	options MonitorOptions
+
+	// refreshMu serializes refreshes, and changes to how they are applied.
+	refreshMu sync.Mutex
+
+	// mu guards everything below.
 	mu                 sync.Mutex
+	version            uint64
 	rawFeatureInstance []byte
 	featureInstance    *FeatureInstance
-	subscriptions      map[chan FeatureInstance]bool
+	effectiveConfig    EffectiveConfig
+	stale              bool
+	subscribers        map[*subscriber]bool
+	refreshHandlers    map[*func(error)]bool
+	bindings           []*ConfigBinding
+	schema             *JSONSchema
+}
+
+// MonitorOptions configure a FeatureInstanceMonitor.
+type MonitorOptions struct {
+	// CachePath, if set, is a file in which the last accepted FeatureInstance
+	// is saved whenever it changes. If the FeatureInstance cannot be retrieved
+	// when the monitor is created, the cached FeatureInstance is used instead,
+	// and the monitor is stale until a refresh succeeds.
+	CachePath string
+	// Log receives messages about the monitor, with secrets redacted.
+	Log Log
+	// MaxBackoff is the longest WatchForChanges will wait between polls
+	// after repeated failures. Defaults to 5 minutes.
+	MaxBackoff time.Duration
+	// StartSystem starts the Beacon system for each worker run by
+	// RunWhileEnabled, usually using BaseClient.StartSystem.
+	StartSystem func(featureInstance FeatureInstance) RunningSystem
+	// Keyring, if set, is used to decrypt encrypted values in the config,
+	// see Config. Values which cannot be decrypted are logged and removed.
+	Keyring *Keyring
+	// FeatureConfig, usually Feature.Config, holds feature-wide defaults which
+	// the instance's Config is merged over, see NewEffectiveConfig.
+	FeatureConfig interface{}
+	// ConfigOverrides are merged over the instance's Config, like the layers
+	// returned by EnvConfigOverrides and FileConfigOverrides.
+	ConfigOverrides []ConfigLayer
+}
+
+// ConfigBinding holds the config bound to a struct by
+// FeatureInstanceMonitor.Bind. It is safe for concurrent use.
+type ConfigBinding struct {
+	typ     reflect.Type
+	options BindOptions
+
+	mu      sync.RWMutex
+	current reflect.Value
+}
+
+// Load copies the most recently bound config into target, which must be a
+// pointer to the type of the struct passed to Bind.
+func (b *ConfigBinding) Load(target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Type().Elem() != b.typ {
+		return fmt.Errorf("config binding target must be a non-nil *%s, got %T", b.typ, target)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	v.Elem().Set(b.current)
+	return nil
+}
+
+// Value returns a pointer to a copy of the most recently bound config.
+func (b *ConfigBinding) Value() interface{} {
+	v := reflect.New(b.typ)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	v.Elem().Set(b.current)
+	return v.Interface()
+}
+
+func (b *ConfigBinding) set(v reflect.Value) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.current = v
 }
 
 // NewFeatureInstanceMonitor returns a new FeatureInstanceMonitor
-// which will retrieve the FeatureInstance and poll for changes.
-func NewFeatureInstanceMonitor(configURL string) (*FeatureInstanceMonitor, error) {
+// which will retrieve the FeatureInstance from source and poll for changes.
+func NewFeatureInstanceMonitor(source ConfigSource) (*FeatureInstanceMonitor, error) {
+	return NewFeatureInstanceMonitorWithOptions(source, MonitorOptions{})
+}
+
+// NewFeatureInstanceMonitorWithOptions returns a new FeatureInstanceMonitor
+// which will retrieve the FeatureInstance from source and poll for changes.
+// If the FeatureInstance cannot be retrieved and options.CachePath is set,
+// the monitor starts with the cached FeatureInstance instead; see Stale.
+func NewFeatureInstanceMonitorWithOptions(source ConfigSource, options MonitorOptions) (*FeatureInstanceMonitor, error) {
+	if options.Log == nil {
+		options.Log = EmptyLog{}
+	}
+	options.Log = NewRedactingLog(options.Log)
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaultMaxBackoff
+	}
 
 	s := &FeatureInstanceMonitor{
-		ConfigURL:     configURL,
-		subscriptions: make(map[chan FeatureInstance]bool),
+		Source:          source,
+		options:         options,
+		subscribers:     make(map[*subscriber]bool),
+		refreshHandlers: make(map[*func(error)]bool),
 	}
 
 	_, err := s.Refresh()
 	if err != nil {
-		return nil, err
+		if options.CachePath == "" {
+			return nil, err
+		}
+		if cacheErr := s.loadCache(); cacheErr != nil {
+			return nil, fmt.Errorf("%s (cached config could not be used: %s)", err, cacheErr)
+		}
+		s.logger().Warn("config could not be retrieved, using stale cached config", map[string]interface{}{
+			"source": source.String(),
+			"cache":  options.CachePath,
+			"error":  err,
+			"stale":  true,
+		})
 	}
 
 	return s, nil
 }
 
+// NewFeatureInstanceMonitorFromURL returns a new FeatureInstanceMonitor
+// which will retrieve the FeatureInstance from the ConfigSource for
+// configURL, as described by NewConfigSource.
+func NewFeatureInstanceMonitorFromURL(configURL string) (*FeatureInstanceMonitor, error) {
+	source, err := NewConfigSource(configURL)
+	if err != nil {
+		return nil, err
+	}
+	return NewFeatureInstanceMonitor(source)
+}
+
 // Refresh re-acquires the config and returns true if there have been changes.
+// If the config could not be acquired or was rejected, the error is also
+// passed to the handlers registered with OnError.
 func (s *FeatureInstanceMonitor) Refresh() (bool, error) {
-	tmpDir, _ := ioutil.TempDir(os.TempDir(), "beacon")
-	fileName := "featureInstance.json"
-	filePath := filepath.Join(tmpDir, fileName)
-	pwd, _ := filepath.Abs(".")
-	client := &getter.Client{
-		Src: s.ConfigURL,
-		Pwd: pwd,
-		Dst: filePath,
-	}
-	defer os.RemoveAll(tmpDir)
-
-	err := client.Get()
+	changed, err := s.refresh()
+	s.notifyRefresh(err)
+	return changed, err
+}
+
+func (s *FeatureInstanceMonitor) refresh() (bool, error) {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+
+	latestBytes, err := s.Source.Fetch(ctx)
+	if err == ErrConfigNotModified {
+		return false, nil
+	}
 	if err != nil {
-		return false, fmt.Errorf("error getting config from %q: %s", s.ConfigURL, err)
+		return false, err
 	}
 
-	latestBytes, err := ioutil.ReadFile(filePath)
-
-	if bytes.Equal(latestBytes, s.rawFeatureInstance) {
+	s.mu.Lock()
+	unchanged := bytes.Equal(latestBytes, s.rawFeatureInstance)
+	s.mu.Unlock()
+	if unchanged {
+		s.clearStale()
 		return false, nil
 	}
 
-	featureInstance := new(FeatureInstance)
-	err = json.Unmarshal(latestBytes, featureInstance)
+	featureInstance, err := DecodeFeatureInstance(s.Source.String(), latestBytes)
 	if err != nil {
 		return false, fmt.Errorf("error deserializing config: %s", err)
 	}
 
+	effective, err := s.processConfig(*featureInstance)
+	if err != nil {
+		return false, err
+	}
+
+	if schema := s.configSchema(); schema != nil {
+		if err = schema.Validate(effective.Config); err != nil {
+			return false, err
+		}
+	}
+
+	bound, err := s.bindConfig(effective.Config)
+	if err != nil {
+		return false, err
+	}
+
+	s.clearStale()
+	s.saveCache(latestBytes)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, b := range s.bindings {
+		b.set(bound[i])
+	}
+
 	s.rawFeatureInstance = latestBytes
 	s.featureInstance = featureInstance
+	s.effectiveConfig = effective
+	s.version++
 
-	s.notifySubscribers(*s.featureInstance)
+	for sub := range s.subscribers {
+		sub.notify()
+	}
 
 	return true, nil
 }
 
+// Stale returns true if the FeatureInstance was loaded from the cache
+// because it could not be retrieved from the source, and no refresh
+// has succeeded since.
+func (s *FeatureInstanceMonitor) Stale() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stale
+}
+
+func (s *FeatureInstanceMonitor) clearStale() {
+	s.mu.Lock()
+	wasStale := s.stale
+	s.stale = false
+	s.mu.Unlock()
+
+	if wasStale {
+		s.logger().Debug("config retrieved, replacing stale cached config", map[string]interface{}{
+			"source": s.Source.String(),
+		})
+	}
+}
+
+// logger returns the log scoped to the current FeatureInstance.
+func (s *FeatureInstanceMonitor) logger() ScopedLog {
+	var source NRN
+	if path := s.FeatureInstance().Path; path != nil {
+		source, _ = ParseNRN(*path)
+	}
+	return NewScopedLog(s.options.Log, source)
+}
+
 // WatchForChanges polls the config source for changes every interval,
-// and emits changes to subscribers.
+// and emits changes to subscribers. After a failed refresh the next poll
+// is delayed with exponential backoff and jitter, up to
+// MonitorOptions.MaxBackoff, until a refresh succeeds. Errors are logged
+// and passed to the handlers registered with OnError. When ctx is done
+// all subscriptions are closed.
 func (s *FeatureInstanceMonitor) WatchForChanges(ctx context.Context, interval time.Duration) {
 	go func() {
+		failures := 0
 		for {
 			select {
-			case <-time.After(interval):
-				s.Refresh()
-			case <-ctx.Done():
-				s.mu.Lock()
-				defer s.mu.Unlock()
-				for k := range s.subscriptions {
-					close(k)
+			case <-time.After(s.pollDelay(interval, failures)):
+				if _, err := s.Refresh(); err != nil {
+					failures++
+					s.logger().Error("error refreshing config", err, map[string]interface{}{
+						"source":   s.Source.String(),
+						"failures": failures,
+					})
+				} else {
+					failures = 0
 				}
+			case <-ctx.Done():
+				s.closeSubscribers()
 				return
 			}
 		}
 	}()
 }
 
-func (s *FeatureInstanceMonitor) notifySubscribers(fi FeatureInstance) {
+// pollDelay returns the time to wait before the next poll, after the
+// given number of consecutive failures.
+func (s *FeatureInstanceMonitor) pollDelay(interval time.Duration, failures int) time.Duration {
+	if failures == 0 {
+		return interval
+	}
+	max := s.options.MaxBackoff
+	if max < interval {
+		max = interval
+	}
+	delay := interval
+	for i := 0; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	// Waiting between half and all of the delay keeps many instances
+	// of a service from polling a recovering source in lockstep.
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// OnError registers fn to be invoked with each error returned by Refresh,
+// including refreshes made by WatchForChanges. fn is invoked on the
+// refreshing goroutine, so it should not block. Invoking the returned
+// function unregisters fn.
+func (s *FeatureInstanceMonitor) OnError(fn func(error)) (stop func()) {
+	return s.onRefresh(func(err error) {
+		if err != nil {
+			fn(err)
+		}
+	})
+}
+
+// BindExpectation reports the health of config delivery to exp: it is
+// fulfilled after each successful refresh, including refreshes which find
+// no changes, and failed with the error after each failed refresh.
+// Invoking the returned function stops reporting to exp.
+func (s *FeatureInstanceMonitor) BindExpectation(exp RunningExpectation) (stop func()) {
+	return s.onRefresh(func(err error) {
+		if err != nil {
+			exp.Fail(RedactString(err.Error()))
+		} else {
+			exp.Fulfil(fmt.Sprintf("config version %d", s.Version()))
+		}
+	})
+}
+
+// onRefresh registers fn to be invoked with the result of each refresh.
+func (s *FeatureInstanceMonitor) onRefresh(fn func(error)) (stop func()) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for k := range s.subscriptions {
-		select {
-		case k <- fi:
-		default:
-		}
+	key := &fn
+	s.refreshHandlers[key] = true
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.refreshHandlers, key)
+	}
+}
+
+func (s *FeatureInstanceMonitor) notifyRefresh(err error) {
+	s.mu.Lock()
+	handlers := make([]func(error), 0, len(s.refreshHandlers))
+	for fn := range s.refreshHandlers {
+		handlers = append(handlers, *fn)
+	}
+	s.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(err)
 	}
 }
 
 // Subscribe returns a channel which will emit a FeatureInstance immediately.
-// If WatchForChanges has been called, it will also emit a Config
-// whenever the config changes.
+// If WatchForChanges has been called, it will also emit a FeatureInstance
+// whenever the config changes. A subscriber which falls behind skips
+// intermediate versions, but always eventually receives the latest one.
 func (s *FeatureInstanceMonitor) Subscribe() chan FeatureInstance {
-	s.mu.Lock()
-	defer s.mu.Unlock()
 	c := make(chan FeatureInstance, 1)
-	s.subscriptions[c] = true
-	c <- s.FeatureInstance()
+	s.subscribe(c, func(change ConfigChange, done <-chan struct{}) {
+		select {
+		case c <- change.New:
+		case <-done:
+		}
+	}, func() {
+		close(c)
+	})
 	return c
 }
 
-// Unsubscribe stops sending signals on the channel.
+// Unsubscribe stops sending signals on the channel and closes it.
 func (s *FeatureInstanceMonitor) Unsubscribe(c chan FeatureInstance) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.subscriptions, c)
+	s.unsubscribe(c)
+}
+
+// SubscribeChanges returns a channel which will emit a ConfigChange from an
+// empty FeatureInstance to the current one immediately. If WatchForChanges has
+// been called, it will also emit a ConfigChange whenever the FeatureInstance
+// changes. A subscriber which falls behind skips intermediate versions, but
+// always eventually receives the latest one, and each ConfigChange describes
+// the change from the version the subscriber last received.
+func (s *FeatureInstanceMonitor) SubscribeChanges() chan ConfigChange {
+	c := make(chan ConfigChange, 1)
+	s.subscribe(c, func(change ConfigChange, done <-chan struct{}) {
+		select {
+		case c <- change:
+		case <-done:
+		}
+	}, func() {
+		close(c)
+	})
+	return c
+}
+
+// UnsubscribeChanges stops sending changes on the channel and closes it.
+func (s *FeatureInstanceMonitor) UnsubscribeChanges(c chan ConfigChange) {
+	s.unsubscribe(c)
+}
+
+// OnChange invokes fn with a ConfigChange from an empty FeatureInstance to the
+// current one, and then with each change. Invocations are sequential and made
+// on a goroutine owned by the subscription; if fn is slow, intermediate
+// versions are skipped but fn is always eventually invoked with the latest one.
+// Invoking the returned function stops the subscription.
+func (s *FeatureInstanceMonitor) OnChange(fn func(ConfigChange)) (stop func()) {
+	sub := s.subscribe(nil, func(change ConfigChange, done <-chan struct{}) {
+		fn(change)
+	}, nil)
+	return func() {
+		s.removeSubscriber(sub)
+	}
+}
+
+// WatchPath invokes fn with each change which affects the value at path
+// within the Config, or anything beneath it. The path is dot-delimited,
+// like "db.url"; an empty path watches the whole Config. If the value is
+// set, fn is invoked with the current config immediately. Invoking the
+// returned function stops watching.
+func (s *FeatureInstanceMonitor) WatchPath(path string, fn func(ConfigChange)) (stop func()) {
+	return s.OnChange(func(change ConfigChange) {
+		if change.ConfigChanged(path) {
+			fn(change)
+		}
+	})
 }
 
 // FeatureInstance returns the latest version of the FeatureInstance.
 func (s *FeatureInstanceMonitor) FeatureInstance() FeatureInstance {
+	fi, _ := s.Current()
+	return fi
+}
+
+// Version returns the version of the latest FeatureInstance, which
+// is incremented each time a change is accepted by Refresh.
+func (s *FeatureInstanceMonitor) Version() uint64 {
+	_, version := s.Current()
+	return version
+}
+
+// Current returns the latest version of the FeatureInstance and its version number.
+func (s *FeatureInstanceMonitor) Current() (FeatureInstance, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.featureInstance == nil {
-		return FeatureInstance{}
+		return FeatureInstance{}, s.version
 	}
-	return *s.featureInstance
+	return *s.featureInstance, s.version
+}
+
+// Config returns the Config of the latest FeatureInstance merged with
+// MonitorOptions.FeatureConfig and MonitorOptions.ConfigOverrides, with
+// references resolved, see ResolveConfig, and encrypted values decrypted using
+// MonitorOptions.Keyring. The Config of FeatureInstance and of the
+// FeatureInstances sent to subscribers is left as it was retrieved.
+func (s *FeatureInstanceMonitor) Config() interface{} {
+	return s.EffectiveConfig().Config
+}
+
+// EffectiveConfig returns Config along with the layer each value came from.
+func (s *FeatureInstanceMonitor) EffectiveConfig() EffectiveConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.effectiveConfig
 }
 
-// ExtractConfig takes the Config property of this FeatureInstance and
-// unmarshalls it into `to`.
+// ExtractConfig unmarshalls Config into `to`, like FeatureInstance.ExtractConfig.
+func (s *FeatureInstanceMonitor) ExtractConfig(to interface{}) error {
+	return FeatureInstance{Config: s.Config()}.ExtractConfig(to)
+}
+
+// processConfig returns the EffectiveConfig of fi as returned by EffectiveConfig.
+func (s *FeatureInstanceMonitor) processConfig(fi FeatureInstance) (EffectiveConfig, error) {
+	return processConfig(fi, s.options, s.logger())
+}
+
+// processConfig merges the config of fi as configured by options, resolves
+// its references and decrypts it. Values which cannot be decrypted are logged
+// to log and removed.
+func processConfig(fi FeatureInstance, options MonitorOptions, log ScopedLog) (EffectiveConfig, error) {
+	effective, err := NewEffectiveConfig(Feature{Config: options.FeatureConfig}, fi, options.ConfigOverrides...)
+	if err != nil {
+		return effective, err
+	}
+	effective.Config, err = ResolveConfig(effective.Config)
+	if err != nil || options.Keyring == nil {
+		return effective, err
+	}
+	effective.Config, err = options.Keyring.DecryptConfig(effective.Config)
+	if decryptionErr, ok := err.(*ConfigDecryptionError); ok {
+		for _, e := range decryptionErr.Errors {
+			log.Error("error decrypting config value, it has been removed", e.Err, map[string]interface{}{"path": e.Path})
+			effective.forget(e.Path)
+		}
+		return effective, nil
+	}
+	return effective, err
+}
+
+// SetConfigSchema sets the JSON schema which the config of the feature instance
+// must conform to, usually Feature.InstanceConfigSchema. Once a schema has been
+// set, changes whose config does not conform are rejected by Refresh and are
+// not sent to subscribers; the last valid FeatureInstance is kept instead.
+// Returns an error if the schema is invalid or the current config does not conform.
+func (s *FeatureInstanceMonitor) SetConfigSchema(schema interface{}) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	compiled, err := CompileJSONSchema(schema)
+	if err != nil {
+		return err
+	}
+	if err = compiled.Validate(s.Config()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schema = compiled
+	return nil
+}
+
+func (s *FeatureInstanceMonitor) configSchema() *JSONSchema {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.schema
+}
+
+// Bind binds the Config of the current FeatureInstance to target, which must be
+// a pointer to a struct, and returns a ConfigBinding which is re-bound to a
+// new value of the same type whenever the config changes. See BindConfig for
+// how the config is decoded and validated; the config is processed first, see
+// Config. target itself is only written by Bind, so use ConfigBinding.Load or
+// Value to read later versions of the config. Once a target has been bound,
+// changes whose config cannot be bound are rejected by Refresh and are not
+// sent to subscribers; the previous FeatureInstance is kept instead. The
+// binding is updated before subscribers are notified of a change.
+func (s *FeatureInstanceMonitor) Bind(target interface{}, options BindOptions) (*ConfigBinding, error) {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	if err := BindConfig(s.Config(), target, options); err != nil {
+		return nil, fmt.Errorf("error binding config: %s", err)
+	}
+	v := reflect.ValueOf(target).Elem()
+	b := &ConfigBinding{typ: v.Type(), options: options}
+	current := reflect.New(b.typ).Elem()
+	current.Set(v)
+	b.set(current)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bindings = append(s.bindings, b)
+	return b, nil
+}
+
+// bindConfig binds the resolved config to new values of each bound type,
+// returning the values in the same order as s.bindings.
+func (s *FeatureInstanceMonitor) bindConfig(config interface{}) ([]reflect.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make([]reflect.Value, len(s.bindings))
+	for i, b := range s.bindings {
+		v := reflect.New(b.typ)
+		if err := BindConfig(config, v.Interface(), b.options); err != nil {
+			return nil, fmt.Errorf("error binding config to %s: %s", b.typ, err)
+		}
+		values[i] = v.Elem()
+	}
+	return values, nil
+}
+
+// ExtractConfig takes the Config property of this FeatureInstance, resolves
+// any references in it as described by ResolveConfig, and unmarshalls it into
+// `to`. Use BindConfig to apply defaults and validate the config.
 func (s FeatureInstance) ExtractConfig(to interface{}) error {
-	return mapstructure.Decode(s.Config, to)
+	config, err := ResolveConfig(s.Config)
+	if err != nil {
+		return err
+	}
+	return mapstructure.Decode(config, to)
 }
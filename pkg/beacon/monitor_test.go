@@ -0,0 +1,114 @@
+package beacon_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+// recordingExpectation is a RunningExpectation which records the reports made to it.
+type recordingExpectation struct {
+	mu      sync.Mutex
+	reports []string
+}
+
+func (r *recordingExpectation) record(report string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, report)
+}
+
+func (r *recordingExpectation) Reports() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.reports...)
+}
+
+func (r *recordingExpectation) Fulfil(message string) { r.record("fulfil: " + message) }
+func (r *recordingExpectation) Fail(message string)   { r.record("fail: " + message) }
+func (r *recordingExpectation) Reschedule(message string, rescheduleTo time.Time) {
+	r.record("reschedule: " + message)
+}
+func (r *recordingExpectation) Retire() { r.record("retire") }
+
+var _ = Describe("FeatureInstanceMonitor health", func() {
+
+	var (
+		dir     string
+		path    string
+		monitor *FeatureInstanceMonitor
+		log     *recordingLog
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "instance.json")
+		writeFeatureInstance(path, `{"config":{"n":1}}`)
+		log = &recordingLog{}
+		monitor, err = NewFeatureInstanceMonitorWithOptions(NewFileSource(path), MonitorOptions{
+			Log:        log,
+			MaxBackoff: 20 * time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should report refreshes to a bound expectation", func() {
+		exp := &recordingExpectation{}
+		stop := monitor.BindExpectation(exp)
+
+		monitor.Refresh()
+		writeFeatureInstance(path, `not json`)
+		monitor.Refresh()
+		stop()
+		monitor.Refresh()
+
+		reports := exp.Reports()
+		Expect(reports).To(HaveLen(2))
+		Expect(reports[0]).To(Equal("fulfil: config version 1"))
+		Expect(reports[1]).To(HavePrefix("fail: error deserializing config"))
+	})
+
+	It("should keep polling and reporting errors while the source is broken", func() {
+		var (
+			mu     sync.Mutex
+			errors int
+		)
+		monitor.OnError(func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errors++
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		Expect(os.Remove(path)).To(Succeed())
+		monitor.WatchForChanges(ctx, 5*time.Millisecond)
+
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return errors
+		}).Should(BeNumerically(">=", 3))
+		Expect(log.Entries()).ToNot(BeEmpty())
+		Expect(log.Entries()[0].msg).To(Equal("error refreshing config"))
+
+		writeFeatureInstance(path, `{"config":{"n":2}}`)
+		Eventually(monitor.FeatureInstance).Should(WithTransform(func(fi FeatureInstance) interface{} {
+			return fi.Config
+		}, HaveKeyWithValue("n", float64(2))))
+	})
+})
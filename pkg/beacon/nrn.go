@@ -16,6 +16,36 @@ type NRN struct {
 	Name     string
 }
 
+// FeatureNRN returns the NRN of the feature with the given name and version,
+// in the same form the server assigns to Feature.Path.
+func FeatureNRN(tenant, name, version string) NRN {
+	return NRN{
+		Type:    "ftr",
+		Tenant:  tenant,
+		Feature: name,
+		Version: version,
+		Name:    name,
+	}
+}
+
+// FeatureInstanceNRN returns the NRN of the named instance of a feature,
+// in the same form the server assigns to FeatureInstance.Path.
+func FeatureInstanceNRN(tenant, name, version, instance string) NRN {
+	return NRN{
+		Type:     "fin",
+		Tenant:   tenant,
+		Feature:  name,
+		Version:  version,
+		Instance: instance,
+		Name:     instance,
+	}
+}
+
+// IsZero returns true if n is the zero NRN.
+func (n NRN) IsZero() bool {
+	return n == NRN{}
+}
+
 func (n NRN) String() string {
 	return fmt.Sprintf("nrn:beacon:%s:%s:%s:%s:%s:%s:%s", n.Tenant, n.Type, n.Feature, n.Version, n.Instance, n.System, n.Name)
 }
@@ -1,10 +1,10 @@
-package beacon
+package beacon_test
 
 import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
-	. "github.com/naveego/beacon-go"
+	. "github.com/naveego/beacon-go/pkg/beacon"
 )
 
 var _ = Describe("Nrn", func() {
@@ -49,4 +49,15 @@ var _ = Describe("Nrn", func() {
 				Name:     "system-A",
 			}))
 	})
+
+	It("should create feature nrn", func() {
+		Expect(FeatureNRN("test-tenant", "feature-A", "1.0.0").String()).
+			To(Equal("nrn:beacon:test-tenant:ftr:feature-A:1.0.0:::feature-A"))
+	})
+
+	It("should create feature instance nrn", func() {
+		nrn := FeatureInstanceNRN("test-tenant", "feature-A", "1.0.0", "instance-1")
+		Expect(nrn.String()).To(Equal("nrn:beacon:test-tenant:fin:feature-A:1.0.0:instance-1::instance-1"))
+		Expect(ParseNRN(nrn.String())).To(Equal(nrn))
+	})
 })
@@ -0,0 +1,572 @@
+package beacon
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONSchema is a compiled JSON schema, used to validate feature instance
+// configs against Feature.InstanceConfigSchema.
+//
+// A subset of draft-07 is supported: type, enum, const, properties, required,
+// additionalProperties, minProperties, maxProperties, items, minItems,
+// maxItems, uniqueItems, pattern, minLength, maxLength, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, multipleOf, allOf, anyOf, oneOf and not.
+// Other keywords are ignored.
+type JSONSchema struct {
+	// always is set for the boolean schemas true and false.
+	always *bool
+
+	types                []string
+	enum                 []interface{}
+	constValue           *interface{}
+	properties           map[string]*JSONSchema
+	required             []string
+	additionalProperties *JSONSchema
+	minProperties        *int
+	maxProperties        *int
+	items                *JSONSchema
+	tupleItems           []*JSONSchema
+	minItems             *int
+	maxItems             *int
+	uniqueItems          bool
+	pattern              *regexp.Regexp
+	minLength            *int
+	maxLength            *int
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	multipleOf           *float64
+	allOf                []*JSONSchema
+	anyOf                []*JSONSchema
+	oneOf                []*JSONSchema
+	not                  *JSONSchema
+}
+
+// SchemaError is a single violation of a JSON schema.
+type SchemaError struct {
+	// Path is the JSON pointer to the invalid value.
+	Path    string
+	Message string
+}
+
+func (e SchemaError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: %s", path, e.Message)
+}
+
+// SchemaValidationError is returned when a value does not conform to a JSONSchema.
+type SchemaValidationError struct {
+	Errors []SchemaError
+}
+
+func (e *SchemaValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("config does not match schema: %s", strings.Join(msgs, "; "))
+}
+
+// CompileJSONSchema compiles a JSON schema document, which may be a JSON
+// string or []byte, or a value which has already been unmarshalled from JSON
+// such as Feature.InstanceConfigSchema.
+func CompileJSONSchema(doc interface{}) (*JSONSchema, error) {
+	normalized, err := normalizeJSON(doc, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %s", err)
+	}
+	return compileSchema(normalized, "")
+}
+
+// ValidateJSONSchema validates value against the schema document,
+// returning a *SchemaValidationError if it does not conform.
+func ValidateJSONSchema(schema interface{}, value interface{}) error {
+	s, err := CompileJSONSchema(schema)
+	if err != nil {
+		return err
+	}
+	return s.Validate(value)
+}
+
+// Validate returns a *SchemaValidationError describing every way in which value
+// does not conform to the schema, or nil if it does. Values which are not
+// already in the form produced by json.Unmarshal are converted to it first.
+func (s *JSONSchema) Validate(value interface{}) error {
+	normalized, err := normalizeJSON(value, false)
+	if err != nil {
+		return err
+	}
+	var errs []SchemaError
+	s.validate(normalized, "", &errs)
+	if len(errs) > 0 {
+		return &SchemaValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// normalizeJSON converts v to the types produced by json.Unmarshal
+// into an interface{}. If parseText is true, strings and byte slices
+// are parsed as JSON.
+func normalizeJSON(v interface{}, parseText bool) (interface{}, error) {
+	var b []byte
+	switch t := v.(type) {
+	case string:
+		if !parseText {
+			return v, nil
+		}
+		b = []byte(t)
+	case []byte:
+		if !parseText {
+			return v, nil
+		}
+		b = t
+	default:
+		if isJSONValue(v) {
+			return v, nil
+		}
+		var err error
+		b, err = json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var out interface{}
+	err := json.Unmarshal(b, &out)
+	return out, err
+}
+
+// isJSONValue returns true if v only contains the types produced
+// by json.Unmarshal into an interface{}.
+func isJSONValue(v interface{}) bool {
+	switch t := v.(type) {
+	case nil, bool, float64, string:
+		return true
+	case map[string]interface{}:
+		for _, e := range t {
+			if !isJSONValue(e) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		for _, e := range t {
+			if !isJSONValue(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func compileSchema(doc interface{}, path string) (*JSONSchema, error) {
+	s := new(JSONSchema)
+
+	if b, ok := doc.(bool); ok {
+		s.always = &b
+		return s, nil
+	}
+
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid schema at %q: expected an object or boolean, got %T", path, doc)
+	}
+
+	var err error
+	fail := func(keyword string, msg string, args ...interface{}) error {
+		return fmt.Errorf("invalid schema at %q: %s %s", path+"/"+keyword, keyword, fmt.Sprintf(msg, args...))
+	}
+
+	for keyword, value := range m {
+		switch keyword {
+		case "type":
+			switch t := value.(type) {
+			case string:
+				s.types = []string{t}
+			case []interface{}:
+				for _, e := range t {
+					name, ok := e.(string)
+					if !ok {
+						return nil, fail(keyword, "must be a string or an array of strings")
+					}
+					s.types = append(s.types, name)
+				}
+			default:
+				return nil, fail(keyword, "must be a string or an array of strings")
+			}
+			for _, name := range s.types {
+				switch name {
+				case "null", "boolean", "object", "array", "number", "integer", "string":
+				default:
+					return nil, fail(keyword, "has unknown type %q", name)
+				}
+			}
+		case "enum":
+			values, ok := value.([]interface{})
+			if !ok {
+				return nil, fail(keyword, "must be an array")
+			}
+			s.enum = values
+		case "const":
+			v := value
+			s.constValue = &v
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fail(keyword, "must be an object")
+			}
+			s.properties = make(map[string]*JSONSchema, len(props))
+			for name, prop := range props {
+				if s.properties[name], err = compileSchema(prop, path+"/properties/"+escapePointer(name)); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			names, ok := value.([]interface{})
+			if !ok {
+				return nil, fail(keyword, "must be an array of strings")
+			}
+			for _, e := range names {
+				name, ok := e.(string)
+				if !ok {
+					return nil, fail(keyword, "must be an array of strings")
+				}
+				s.required = append(s.required, name)
+			}
+		case "additionalProperties":
+			if s.additionalProperties, err = compileSchema(value, path+"/"+keyword); err != nil {
+				return nil, err
+			}
+		case "items":
+			if tuple, ok := value.([]interface{}); ok {
+				for i, item := range tuple {
+					itemSchema, err := compileSchema(item, fmt.Sprintf("%s/items/%d", path, i))
+					if err != nil {
+						return nil, err
+					}
+					s.tupleItems = append(s.tupleItems, itemSchema)
+				}
+			} else if s.items, err = compileSchema(value, path+"/"+keyword); err != nil {
+				return nil, err
+			}
+		case "uniqueItems":
+			b, ok := value.(bool)
+			if !ok {
+				return nil, fail(keyword, "must be a boolean")
+			}
+			s.uniqueItems = b
+		case "pattern":
+			p, ok := value.(string)
+			if !ok {
+				return nil, fail(keyword, "must be a string")
+			}
+			if s.pattern, err = regexp.Compile(p); err != nil {
+				return nil, fail(keyword, "is not a valid regular expression: %s", err)
+			}
+		case "minProperties", "maxProperties", "minItems", "maxItems", "minLength", "maxLength":
+			f, ok := value.(float64)
+			if !ok || f < 0 || f != math.Trunc(f) {
+				return nil, fail(keyword, "must be a non-negative integer")
+			}
+			n := int(f)
+			switch keyword {
+			case "minProperties":
+				s.minProperties = &n
+			case "maxProperties":
+				s.maxProperties = &n
+			case "minItems":
+				s.minItems = &n
+			case "maxItems":
+				s.maxItems = &n
+			case "minLength":
+				s.minLength = &n
+			case "maxLength":
+				s.maxLength = &n
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+			f, ok := value.(float64)
+			if !ok {
+				return nil, fail(keyword, "must be a number")
+			}
+			switch keyword {
+			case "minimum":
+				s.minimum = &f
+			case "maximum":
+				s.maximum = &f
+			case "exclusiveMinimum":
+				s.exclusiveMinimum = &f
+			case "exclusiveMaximum":
+				s.exclusiveMaximum = &f
+			case "multipleOf":
+				if f <= 0 {
+					return nil, fail(keyword, "must be greater than 0")
+				}
+				s.multipleOf = &f
+			}
+		case "allOf", "anyOf", "oneOf":
+			subs, ok := value.([]interface{})
+			if !ok || len(subs) == 0 {
+				return nil, fail(keyword, "must be a non-empty array")
+			}
+			var compiled []*JSONSchema
+			for i, sub := range subs {
+				c, err := compileSchema(sub, fmt.Sprintf("%s/%s/%d", path, keyword, i))
+				if err != nil {
+					return nil, err
+				}
+				compiled = append(compiled, c)
+			}
+			switch keyword {
+			case "allOf":
+				s.allOf = compiled
+			case "anyOf":
+				s.anyOf = compiled
+			case "oneOf":
+				s.oneOf = compiled
+			}
+		case "not":
+			if s.not, err = compileSchema(value, path+"/"+keyword); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func (s *JSONSchema) validate(value interface{}, path string, errs *[]SchemaError) {
+	fail := func(msg string, args ...interface{}) {
+		*errs = append(*errs, SchemaError{Path: path, Message: fmt.Sprintf(msg, args...)})
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			fail("no value is allowed here")
+		}
+		return
+	}
+
+	if len(s.types) > 0 {
+		actual := jsonType(value)
+		ok := false
+		for _, t := range s.types {
+			if t == actual || (t == "number" && actual == "integer") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			fail("expected %s, got %s", strings.Join(s.types, " or "), actual)
+			// The remaining keywords would only produce noise.
+			return
+		}
+	}
+
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", formatJSONValues(s.enum))
+		}
+	}
+
+	if s.constValue != nil && !jsonEqual(*s.constValue, value) {
+		fail("must be %s", formatJSONValues([]interface{}{*s.constValue}))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(v, path, errs, fail)
+	case []interface{}:
+		s.validateArray(v, path, errs, fail)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			fail("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match pattern %q", s.pattern.String())
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("must be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("must be <= %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			fail("must be > %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			fail("must be < %v", *s.exclusiveMaximum)
+		}
+		if s.multipleOf != nil {
+			q := v / *s.multipleOf
+			if math.Abs(q-math.Round(q)) > 1e-9 {
+				fail("must be a multiple of %v", *s.multipleOf)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(value, path, errs)
+	}
+
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.matches(value, path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must match at least one schema in anyOf")
+		}
+	}
+
+	if len(s.oneOf) > 0 {
+		count := 0
+		for _, sub := range s.oneOf {
+			if sub.matches(value, path) {
+				count++
+			}
+		}
+		if count != 1 {
+			fail("must match exactly one schema in oneOf, matched %d", count)
+		}
+	}
+
+	if s.not != nil && s.not.matches(value, path) {
+		fail("must not match schema in not")
+	}
+}
+
+func (s *JSONSchema) validateObject(v map[string]interface{}, path string, errs *[]SchemaError, fail func(string, ...interface{})) {
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			*errs = append(*errs, SchemaError{Path: path + "/" + escapePointer(name), Message: "is required"})
+		}
+	}
+	if s.minProperties != nil && len(v) < *s.minProperties {
+		fail("must have at least %d properties", *s.minProperties)
+	}
+	if s.maxProperties != nil && len(v) > *s.maxProperties {
+		fail("must have at most %d properties", *s.maxProperties)
+	}
+
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		propPath := path + "/" + escapePointer(k)
+		if prop, ok := s.properties[k]; ok {
+			prop.validate(v[k], propPath, errs)
+			continue
+		}
+		if s.additionalProperties != nil {
+			if s.additionalProperties.always != nil && !*s.additionalProperties.always {
+				*errs = append(*errs, SchemaError{Path: propPath, Message: "is not an allowed property"})
+				continue
+			}
+			s.additionalProperties.validate(v[k], propPath, errs)
+		}
+	}
+}
+
+func (s *JSONSchema) validateArray(v []interface{}, path string, errs *[]SchemaError, fail func(string, ...interface{})) {
+	if s.minItems != nil && len(v) < *s.minItems {
+		fail("must have at least %d items", *s.minItems)
+	}
+	if s.maxItems != nil && len(v) > *s.maxItems {
+		fail("must have at most %d items", *s.maxItems)
+	}
+	if s.uniqueItems {
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if jsonEqual(v[i], v[j]) {
+					fail("items %d and %d must be unique", i, j)
+				}
+			}
+		}
+	}
+	for i, item := range v {
+		itemPath := fmt.Sprintf("%s/%d", path, i)
+		switch {
+		case s.tupleItems != nil:
+			if i < len(s.tupleItems) {
+				s.tupleItems[i].validate(item, itemPath, errs)
+			}
+		case s.items != nil:
+			s.items.validate(item, itemPath, errs)
+		}
+	}
+}
+
+// matches returns true if value conforms to s.
+func (s *JSONSchema) matches(value interface{}, path string) bool {
+	var errs []SchemaError
+	s.validate(value, path, &errs)
+	return len(errs) == 0
+}
+
+func jsonType(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if t == math.Trunc(t) && !math.IsInf(t, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func formatJSONValues(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		b, _ := json.Marshal(v)
+		parts[i] = string(b)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// escapePointer escapes a property name for use in a JSON pointer.
+func escapePointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,135 @@
+package beacon_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["db"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "pattern": "^[a-z]+$", "maxLength": 5},
+		"mode": {"enum": ["fast", "safe"]},
+		"db": {
+			"type": "object",
+			"required": ["url"],
+			"properties": {
+				"url": {"type": "string", "minLength": 1},
+				"port": {"type": "integer", "minimum": 1, "maximum": 65535}
+			}
+		},
+		"hosts": {"type": "array", "minItems": 1, "uniqueItems": true, "items": {"type": "string"}}
+	}
+}`
+
+func schemaErrorPaths(err error) []string {
+	Expect(err).To(BeAssignableToTypeOf(&SchemaValidationError{}))
+	var paths []string
+	for _, e := range err.(*SchemaValidationError).Errors {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+var _ = Describe("JSONSchema", func() {
+
+	var sut *JSONSchema
+
+	BeforeEach(func() {
+		var err error
+		sut, err = CompileJSONSchema(testSchema)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should accept valid config", func() {
+		Expect(sut.Validate(map[string]interface{}{
+			"name":  "abc",
+			"mode":  "fast",
+			"db":    map[string]interface{}{"url": "postgres://", "port": 5432},
+			"hosts": []string{"a", "b"},
+		})).To(Succeed())
+	})
+
+	It("should report every violation with its path", func() {
+		err := sut.Validate(map[string]interface{}{
+			"name":  "ABCDEFG",
+			"mode":  "slow",
+			"db":    map[string]interface{}{"port": 1.5},
+			"hosts": []interface{}{"a", "a", 3},
+			"extra": true,
+		})
+		Expect(schemaErrorPaths(err)).To(ConsistOf(
+			"/db/url",
+			"/db/port",
+			"/extra",
+			"/hosts",
+			"/hosts/2",
+			"/mode",
+			"/name",
+			"/name",
+		))
+		Expect(err.Error()).To(ContainSubstring("/db/url: is required"))
+	})
+
+	It("should report missing required property at root", func() {
+		Expect(schemaErrorPaths(sut.Validate(map[string]interface{}{}))).To(ConsistOf("/db"))
+		Expect(schemaErrorPaths(sut.Validate("text"))).To(ConsistOf(""))
+	})
+
+	It("should support combinators", func() {
+		schema, err := CompileJSONSchema(map[string]interface{}{
+			"anyOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "number", "exclusiveMinimum": 0},
+			},
+			"not": map[string]interface{}{"const": "forbidden"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(schema.Validate("ok")).To(Succeed())
+		Expect(schema.Validate(1)).To(Succeed())
+		Expect(schema.Validate(0)).ToNot(Succeed())
+		Expect(schema.Validate("forbidden")).ToNot(Succeed())
+	})
+
+	It("should reject invalid schemas", func() {
+		_, err := CompileJSONSchema(`{"properties": {"a": {"type": "strnig"}}}`)
+		Expect(err).To(MatchError(ContainSubstring("/properties/a/type")))
+		_, err = CompileJSONSchema(`{"pattern": "("}`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should validate feature instance config", func() {
+		feature := Feature{}
+		Expect(feature.ValidateConfig(map[string]interface{}{"anything": 1})).To(Succeed())
+		feature.InstanceConfigSchema = map[string]interface{}{"required": []interface{}{"db"}}
+		Expect(feature.ValidateConfig(map[string]interface{}{})).ToNot(Succeed())
+	})
+
+	Describe("FeatureInstanceMonitor", func() {
+
+		It("should keep last good config when refresh is invalid", func() {
+			dir, err := ioutil.TempDir("", "beacon-test")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "instance.json")
+
+			writeFeatureInstance(path, `{"config":{"db":{"url":"first"}}}`)
+			monitor, err := NewFeatureInstanceMonitorFromURL(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(monitor.SetConfigSchema(testSchema)).To(Succeed())
+
+			writeFeatureInstance(path, `{"config":{"db":{}}}`)
+			_, err = monitor.Refresh()
+			Expect(schemaErrorPaths(err)).To(ConsistOf("/db/url"))
+			Expect(monitor.FeatureInstance().Config).To(HaveKeyWithValue("db", HaveKeyWithValue("url", "first")))
+		})
+	})
+})
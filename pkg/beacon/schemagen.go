@@ -0,0 +1,241 @@
+package beacon
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// JSONSchemaDraft07 is the $schema of documents produced by GenerateJSONSchema.
+const JSONSchemaDraft07 = "http://json-schema.org/draft-07/schema#"
+
+// GenerateJSONSchema generates a JSON schema document describing the config
+// struct v, suitable for Feature.InstanceConfigSchema. v may be a struct,
+// a pointer to a struct, or a reflect.Type.
+//
+// Property names are taken from the json tag, then the mapstructure tag,
+// then the field name. Fields are required unless they are pointers, are
+// tagged omitempty, or have a default. The following tags are also used:
+//
+//	default:"value"        sets "default" (slices are comma-separated)
+//	enum:"a,b,c"           sets "enum"
+//	description:"text"     sets "description"
+//
+// time.Duration fields accept strings or integers, time.Time fields are
+// strings with format date-time, and url.URL fields are strings with
+// format uri. Embedded struct fields of exported types are flattened into
+// their parent.
+func GenerateJSONSchema(v interface{}) (map[string]interface{}, error) {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config schema can only be generated for a struct, got %v", t)
+	}
+
+	g := &schemaGenerator{visiting: make(map[reflect.Type]bool)}
+	schema, err := g.schemaFor(t)
+	if err != nil {
+		return nil, err
+	}
+	schema["$schema"] = JSONSchemaDraft07
+	return schema, nil
+}
+
+// MarshalJSONSchema returns a schema document as indented JSON with sorted
+// keys, so that generated schemas can be committed and diffed.
+func MarshalJSONSchema(schema interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+type schemaGenerator struct {
+	// visiting contains the struct types currently being generated,
+	// so that recursive types don't recurse forever.
+	visiting map[reflect.Type]bool
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) (map[string]interface{}, error) {
+	switch t {
+	case durationType:
+		return map[string]interface{}{"type": []interface{}{"string", "integer"}}, nil
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}, nil
+	case urlType:
+		return map[string]interface{}{"type": "string", "format": "uri"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schemaFor(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.Interface:
+		return map[string]interface{}{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}, nil
+		}
+		items, err := g.schemaFor(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		schema := map[string]interface{}{"type": "array", "items": items}
+		if t.Kind() == reflect.Array {
+			schema["minItems"] = t.Len()
+			schema["maxItems"] = t.Len()
+		}
+		return schema, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("config schema cannot be generated for %s: map keys must be strings", t)
+		}
+		values, err := g.schemaFor(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		if g.visiting[t] {
+			// Recursive types can't be described without references,
+			// so the recursive property is left unconstrained.
+			return map[string]interface{}{"type": "object"}, nil
+		}
+		g.visiting[t] = true
+		defer delete(g.visiting, t)
+
+		properties := make(map[string]interface{})
+		var required []interface{}
+		if err := g.addFields(t, properties, &required); err != nil {
+			return nil, err
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema, nil
+	}
+
+	return nil, fmt.Errorf("config schema cannot be generated for %s", t)
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]interface{}, required *[]interface{}) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		// Unexported embedded types are skipped too, as their fields can't be
+		// set when the config is bound.
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name, omitEmpty, skip := schemaFieldName(field)
+		if skip {
+			continue
+		}
+
+		fieldType := field.Type
+		if field.Anonymous && name == "" {
+			embedded := fieldType
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := g.addFields(embedded, properties, required); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema, err := g.schemaFor(fieldType)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %s", t, field.Name, err)
+		}
+
+		if description, ok := field.Tag.Lookup("description"); ok {
+			schema["description"] = description
+		}
+		if enum, ok := field.Tag.Lookup("enum"); ok {
+			var values []interface{}
+			for _, e := range strings.Split(enum, ",") {
+				value, err := schemaTagValue(fieldType, strings.TrimSpace(e))
+				if err != nil {
+					return fmt.Errorf("%s.%s: invalid enum value %q: %s", t, field.Name, e, err)
+				}
+				values = append(values, value)
+			}
+			schema["enum"] = values
+		}
+		def, hasDefault := field.Tag.Lookup("default")
+		if hasDefault {
+			value, err := schemaTagValue(fieldType, def)
+			if err != nil {
+				return fmt.Errorf("%s.%s: invalid default %q: %s", t, field.Name, def, err)
+			}
+			schema["default"] = value
+		}
+
+		properties[name] = schema
+		if !hasDefault && !omitEmpty && fieldType.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
+
+// schemaFieldName returns the property name for a field, whether it is
+// tagged omitempty, and whether it should be skipped entirely.
+func schemaFieldName(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	for _, tagName := range []string{"json", "mapstructure"} {
+		tag, ok := field.Tag.Lookup(tagName)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		if parts[0] == "-" {
+			return "", false, true
+		}
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				omitEmpty = true
+			}
+		}
+		if parts[0] != "" {
+			return parts[0], omitEmpty, false
+		}
+	}
+	return "", omitEmpty, false
+}
+
+// schemaTagValue converts a value from a default or enum tag into the
+// JSON value it would have in a config.
+func schemaTagValue(t reflect.Type, s string) (interface{}, error) {
+	v := reflect.New(t).Elem()
+	if err := setDefault(v, s); err != nil {
+		return nil, err
+	}
+	switch t {
+	case durationType, timeType, urlType:
+		return s, nil
+	}
+	return normalizeJSON(v.Interface(), false)
+}
@@ -0,0 +1,141 @@
+package beacon_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+type GenBase struct {
+	ID string `json:"id" description:"The identifier."`
+}
+
+// genUnexported can't be set by BindConfig, so it isn't in the schema.
+type genUnexported struct {
+	Secret string `json:"secret"`
+}
+
+type genNode struct {
+	Name     string     `json:"name"`
+	Children []*genNode `json:"children,omitempty"`
+}
+
+type genConfig struct {
+	GenBase
+	genUnexported
+	Mode     string            `json:"mode" enum:"fast,safe" default:"safe"`
+	Port     uint16            `json:"port" default:"8080"`
+	Timeout  time.Duration     `json:"timeout" default:"5s"`
+	Started  *time.Time        `json:"started"`
+	Hosts    []string          `json:"hosts,omitempty"`
+	Labels   map[string]string `json:"labels"`
+	Tree     genNode           `json:"tree"`
+	Internal string            `json:"-"`
+	hidden   string
+}
+
+const genConfigSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "properties": {
+    "hosts": {
+      "items": {
+        "type": "string"
+      },
+      "type": "array"
+    },
+    "id": {
+      "description": "The identifier.",
+      "type": "string"
+    },
+    "labels": {
+      "additionalProperties": {
+        "type": "string"
+      },
+      "type": "object"
+    },
+    "mode": {
+      "default": "safe",
+      "enum": [
+        "fast",
+        "safe"
+      ],
+      "type": "string"
+    },
+    "port": {
+      "default": 8080,
+      "minimum": 0,
+      "type": "integer"
+    },
+    "started": {
+      "format": "date-time",
+      "type": "string"
+    },
+    "timeout": {
+      "default": "5s",
+      "type": [
+        "string",
+        "integer"
+      ]
+    },
+    "tree": {
+      "properties": {
+        "children": {
+          "items": {
+            "type": "object"
+          },
+          "type": "array"
+        },
+        "name": {
+          "type": "string"
+        }
+      },
+      "required": [
+        "name"
+      ],
+      "type": "object"
+    }
+  },
+  "required": [
+    "id",
+    "labels",
+    "tree"
+  ],
+  "type": "object"
+}
+`
+
+var _ = Describe("GenerateJSONSchema", func() {
+
+	It("should generate stable schema from struct", func() {
+		schema, err := GenerateJSONSchema(&genConfig{})
+		Expect(err).ToNot(HaveOccurred())
+		b, err := MarshalJSONSchema(schema)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(Equal(genConfigSchema))
+	})
+
+	It("should produce a schema which validates configs", func() {
+		var feature Feature
+		Expect(feature.SetConfigSchemaFrom(genConfig{})).To(Succeed())
+		Expect(feature.ValidateConfig(map[string]interface{}{
+			"id":      "x",
+			"labels":  map[string]interface{}{},
+			"tree":    map[string]interface{}{"name": "root"},
+			"timeout": "1m",
+		})).To(Succeed())
+		Expect(feature.ValidateConfig(map[string]interface{}{
+			"id":     "x",
+			"labels": map[string]interface{}{},
+			"tree":   map[string]interface{}{"name": "root"},
+			"mode":   "slow",
+		})).ToNot(Succeed())
+	})
+
+	It("should reject non-struct types", func() {
+		_, err := GenerateJSONSchema("string")
+		Expect(err).To(HaveOccurred())
+	})
+})
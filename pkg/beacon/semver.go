@@ -0,0 +1,486 @@
+package beacon
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// SemVer is a semantic version (see https://semver.org).
+type SemVer struct {
+	Major, Minor, Patch uint64
+	// Prerelease contains the dot-separated identifiers after the "-".
+	Prerelease []string
+	// Build contains the dot-separated identifiers after the "+". It is
+	// ignored when comparing versions.
+	Build []string
+}
+
+// semVerPattern is the pattern used by the API to validate versions, except
+// that like SemVer it doesn't allow leading zeros in numbers, including
+// numeric pre-release identifiers.
+var semVerPattern = regexp.MustCompile(`^v?((` + semVerNumber + `)\.(` + semVerNumber + `)\.(` + semVerNumber + `))(?:-(` + semVerPrerelease + `))?(?:\+([\dA-Za-z\-]+(?:\.[\dA-Za-z\-]+)*))?$`)
+
+const (
+	semVerNumber          = `0|[1-9]\d*`
+	semVerPrereleaseIdent = `(?:0|[1-9]\d*|\d*[A-Za-z\-][\dA-Za-z\-]*)`
+	semVerPrerelease      = semVerPrereleaseIdent + `(?:\.` + semVerPrereleaseIdent + `)*`
+)
+
+// ParseSemVer parses a version like "1.2.3", "v1.2.3-beta.1" or "1.2.3+build.5".
+func ParseSemVer(s string) (SemVer, error) {
+	m := semVerPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return SemVer{}, fmt.Errorf("invalid version %q", s)
+	}
+	var v SemVer
+	var err error
+	for i, n := range []*uint64{&v.Major, &v.Minor, &v.Patch} {
+		if *n, err = strconv.ParseUint(m[i+2], 10, 64); err != nil {
+			return SemVer{}, fmt.Errorf("invalid version %q: %s", s, err)
+		}
+	}
+	if m[5] != "" {
+		v.Prerelease = strings.Split(m[5], ".")
+	}
+	if m[6] != "" {
+		v.Build = strings.Split(m[6], ".")
+	}
+	return v, nil
+}
+
+// MustParseSemVer is like ParseSemVer but panics if s is invalid.
+func MustParseSemVer(s string) SemVer {
+	v, err := ParseSemVer(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func (v SemVer) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (v SemVer) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (v *SemVer) UnmarshalText(text []byte) error {
+	parsed, err := ParseSemVer(string(text))
+	if err != nil {
+		return err
+	}
+	*v = parsed
+	return nil
+}
+
+// Compare returns -1, 0 or 1 if v has lower, equal or higher precedence
+// than o. A pre-release has lower precedence than its release, and
+// pre-releases are ordered by comparing their identifiers in turn, numeric
+// identifiers numerically and lower than alphanumeric ones.
+func (v SemVer) Compare(o SemVer) int {
+	for _, c := range [][2]uint64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := comparePrerelease(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(v.Prerelease) < len(o.Prerelease):
+		return -1
+	case len(v.Prerelease) > len(o.Prerelease):
+		return 1
+	}
+	return 0
+}
+
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		if an == bn {
+			return 0
+		}
+		if an < bn {
+			return -1
+		}
+		return 1
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// LessThan returns true if v has lower precedence than o.
+func (v SemVer) LessThan(o SemVer) bool {
+	return v.Compare(o) < 0
+}
+
+// SortSemVers sorts versions in ascending order of precedence.
+func SortSemVers(versions []SemVer) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LessThan(versions[j])
+	})
+}
+
+// VersionRange is a set of versions, in the syntax of ranges accepted by
+// the API's versionRange parameters, which is the syntax of npm. It is
+// one or more sets of comparators separated by "||", and matches versions
+// which satisfy all the comparators of any set. Comparators are:
+//
+//	1.2.3, =1.2.3, >1.2.3, >=1.2.3, <1.2.3, <=1.2.3
+//	1.2.x, 1.x, * (any version with the given prefix; missing parts are x)
+//	~1.2.3 (>=1.2.3 <1.3.0), ~1.2 (>=1.2.0 <1.3.0), ~1 (>=1.0.0 <2.0.0)
+//	^1.2.3 (>=1.2.3 <2.0.0), ^0.2.3 (>=0.2.3 <0.3.0), ^0.0.3 (>=0.0.3 <0.0.4)
+//	1.2.3 - 2.3 (>=1.2.3 <2.4.0)
+//
+// A pre-release version only matches a set if one of the set's comparators
+// has a pre-release of the same major, minor and patch version.
+type VersionRange struct {
+	raw  string
+	sets [][]versionComparator
+}
+
+type versionComparator struct {
+	op      string // "<", "<=", ">", ">=" or "="
+	version SemVer
+}
+
+func (c versionComparator) matches(v SemVer) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return cmp == 0
+}
+
+// ParseVersionRange parses a range like "^1.2.0", ">=1.0.0 <2.0.0 || 3.x".
+func ParseVersionRange(s string) (VersionRange, error) {
+	r := VersionRange{raw: s}
+	for _, set := range strings.Split(s, "||") {
+		comparators, err := parseComparatorSet(strings.TrimSpace(set))
+		if err != nil {
+			return VersionRange{}, fmt.Errorf("invalid version range %q: %s", s, err)
+		}
+		r.sets = append(r.sets, comparators)
+	}
+	return r, nil
+}
+
+// MustParseVersionRange is like ParseVersionRange but panics if s is invalid.
+func MustParseVersionRange(s string) VersionRange {
+	r, err := ParseVersionRange(s)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func (r VersionRange) String() string {
+	return r.raw
+}
+
+// Match returns true if v is in the range.
+func (r VersionRange) Match(v SemVer) bool {
+	for _, set := range r.sets {
+		if matchComparatorSet(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchString returns true if the version s is valid and in the range.
+func (r VersionRange) MatchString(s string) bool {
+	v, err := ParseSemVer(s)
+	return err == nil && r.Match(v)
+}
+
+func matchComparatorSet(set []versionComparator, v SemVer) bool {
+	for _, c := range set {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	if len(v.Prerelease) == 0 {
+		return true
+	}
+	for _, c := range set {
+		if len(c.version.Prerelease) > 0 &&
+			c.version.Major == v.Major && c.version.Minor == v.Minor && c.version.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+var hyphenRange = regexp.MustCompile(`^(\S+)\s+-\s+(\S+)$`)
+
+func parseComparatorSet(s string) ([]versionComparator, error) {
+	if m := hyphenRange.FindStringSubmatch(s); m != nil {
+		lower, err := parsePartial(m[1])
+		if err != nil {
+			return nil, err
+		}
+		upper, err := parsePartial(m[2])
+		if err != nil {
+			return nil, err
+		}
+		return append(lower.comparators(">="), upper.comparators("<=")...), nil
+	}
+
+	var comparators []versionComparator
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields); i++ {
+		token := fields[i]
+		// Allow whitespace between an operator and its version.
+		if strings.Trim(token, "<>=~^") == "" && i+1 < len(fields) {
+			i++
+			token += fields[i]
+		}
+		parsed, err := parseComparator(token)
+		if err != nil {
+			return nil, err
+		}
+		comparators = append(comparators, parsed...)
+	}
+	if len(comparators) == 0 {
+		// An empty set matches any version.
+		comparators = []versionComparator{{op: ">=", version: SemVer{}}}
+	}
+	return comparators, nil
+}
+
+func parseComparator(token string) ([]versionComparator, error) {
+	op := ""
+	for _, prefix := range []string{"<=", ">=", "<", ">", "=", "~>", "~", "^"} {
+		if strings.HasPrefix(token, prefix) {
+			op = prefix
+			break
+		}
+	}
+	p, err := parsePartial(strings.TrimPrefix(token, op))
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "~", "~>":
+		return p.tilde(), nil
+	case "^":
+		return p.caret(), nil
+	case "", "=":
+		return p.comparators("="), nil
+	}
+	return p.comparators(op), nil
+}
+
+// partialVersion is a version in a range, in which the minor and patch
+// versions may be missing or wildcards.
+type partialVersion struct {
+	version SemVer
+	// parts is the number of parts which are not wildcards, from 0 to 3.
+	parts int
+}
+
+var partialPattern = regexp.MustCompile(`^v?(` + semVerNumber + `|[xX*])(?:\.(` + semVerNumber + `|[xX*])(?:\.(` + semVerNumber + `|[xX*])(?:-(` + semVerPrerelease + `))?(?:\+[\dA-Za-z\-]+(?:\.[\dA-Za-z\-]+)*)?)?)?$`)
+
+func parsePartial(s string) (partialVersion, error) {
+	m := partialPattern.FindStringSubmatch(s)
+	if m == nil {
+		return partialVersion{}, fmt.Errorf("invalid version %q", s)
+	}
+	var p partialVersion
+	for i, n := range []*uint64{&p.version.Major, &p.version.Minor, &p.version.Patch} {
+		part := m[i+1]
+		if part == "" || part == "x" || part == "X" || part == "*" {
+			break
+		}
+		var err error
+		if *n, err = strconv.ParseUint(part, 10, 64); err != nil {
+			return partialVersion{}, fmt.Errorf("invalid version %q: %s", s, err)
+		}
+		p.parts++
+	}
+	if m[4] != "" && p.parts == 3 {
+		p.version.Prerelease = strings.Split(m[4], ".")
+	}
+	return p, nil
+}
+
+// next returns the lowest version above every version with the prefix of p,
+// excluding pre-releases. For a full version, the prefix is the whole
+// version without its pre-release.
+func (p partialVersion) next() SemVer {
+	v := SemVer{Major: p.version.Major, Minor: p.version.Minor, Prerelease: []string{"0"}}
+	switch p.parts {
+	case 1:
+		v.Major++
+		v.Minor = 0
+	case 2:
+		v.Minor++
+	case 3:
+		v.Patch = p.version.Patch + 1
+	}
+	return v
+}
+
+// base returns the lowest version with the prefix of p.
+func (p partialVersion) base() SemVer {
+	return p.version
+}
+
+func (p partialVersion) comparators(op string) []versionComparator {
+	if p.parts == 3 {
+		return []versionComparator{{op: op, version: p.version}}
+	}
+	if p.parts == 0 {
+		if op == "<" || op == ">" {
+			// Nothing is below or above every version.
+			return []versionComparator{{op: "<", version: SemVer{Prerelease: []string{"0"}}}}
+		}
+		return []versionComparator{{op: ">=", version: SemVer{}}}
+	}
+	switch op {
+	case "<":
+		return []versionComparator{{op: "<", version: p.base()}}
+	case "<=":
+		return []versionComparator{{op: "<", version: p.next()}}
+	case ">":
+		next := p.next()
+		next.Prerelease = nil
+		return []versionComparator{{op: ">=", version: next}}
+	case ">=":
+		return []versionComparator{{op: ">=", version: p.base()}}
+	}
+	return []versionComparator{{op: ">=", version: p.base()}, {op: "<", version: p.next()}}
+}
+
+func (p partialVersion) tilde() []versionComparator {
+	if p.parts == 0 {
+		return p.comparators("=")
+	}
+	upper := p
+	if upper.parts > 2 {
+		upper.parts = 2
+	}
+	return []versionComparator{{op: ">=", version: p.base()}, {op: "<", version: upper.next()}}
+}
+
+func (p partialVersion) caret() []versionComparator {
+	if p.parts == 0 {
+		return p.comparators("=")
+	}
+	// The upper bound increments the first non-zero part, or the last
+	// part given if all of them are zero.
+	upper := partialVersion{version: p.version, parts: 1}
+	if p.version.Major == 0 && p.parts > 1 {
+		upper.parts = 2
+		if p.version.Minor == 0 && p.parts == 3 {
+			upper.parts = 3
+		}
+	}
+	return []versionComparator{{op: ">=", version: p.base()}, {op: "<", version: upper.next()}}
+}
+
+// LatestFeature returns the feature named name with the highest version in
+// versionRange. Pre-releases are only considered if the range allows them.
+func (client BaseClient) LatestFeature(ctx context.Context, name string, versionRange string) (Feature, error) {
+	r, err := ParseVersionRange(versionRange)
+	if err != nil {
+		return Feature{}, err
+	}
+	list, err := client.GetFeatures(ctx, name, versionRange)
+	if err != nil {
+		return Feature{}, fmt.Errorf("error getting features: %s", err)
+	}
+
+	var latest *Feature
+	var latestVersion SemVer
+	if list.Value != nil {
+		for i, f := range *list.Value {
+			v, err := ParseSemVer(to.String(f.Version))
+			if err != nil || to.String(f.Name) != name || !r.Match(v) {
+				continue
+			}
+			if latest == nil || latestVersion.LessThan(v) {
+				latest, latestVersion = &(*list.Value)[i], v
+			}
+		}
+	}
+	if latest == nil {
+		return Feature{}, fmt.Errorf("no version of feature %q matches %q", name, versionRange)
+	}
+	return *latest, nil
+}
+
+// InstancesCompatibleWith returns the instances of the feature named
+// featureName which can be served by an implementation of version, that is,
+// those whose FeatureVersion v satisfies "^v" includes version: the same
+// major version (or minor version, for 0.x versions) and no later than version.
+func (client BaseClient) InstancesCompatibleWith(ctx context.Context, featureName string, version SemVer) ([]FeatureInstance, error) {
+	list, err := client.GetFeatureInstances(ctx, featureName, "", "", "", "")
+	if err != nil {
+		return nil, fmt.Errorf("error getting feature instances: %s", err)
+	}
+
+	var out []FeatureInstance
+	if list.Value != nil {
+		for _, fi := range *list.Value {
+			v, err := ParseSemVer(to.String(fi.FeatureVersion))
+			if err != nil || to.String(fi.FeatureName) != featureName {
+				continue
+			}
+			if (partialVersion{version: v, parts: 3}).caretRange().Match(version) {
+				out = append(out, fi)
+			}
+		}
+	}
+	return out, nil
+}
+
+// caretRange returns the range "^p".
+func (p partialVersion) caretRange() VersionRange {
+	return VersionRange{raw: "^" + p.version.String(), sets: [][]versionComparator{p.caret()}}
+}
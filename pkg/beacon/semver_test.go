@@ -0,0 +1,163 @@
+package beacon_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("SemVer", func() {
+
+	It("should parse and format versions", func() {
+		v, err := ParseSemVer("v1.2.3-beta.1+build.5")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v).To(Equal(SemVer{Major: 1, Minor: 2, Patch: 3, Prerelease: []string{"beta", "1"}, Build: []string{"build", "5"}}))
+		Expect(v.String()).To(Equal("1.2.3-beta.1+build.5"))
+
+		Expect(ParseSemVer("0.10.0-0.alpha.0a+001")).To(Equal(SemVer{Minor: 10, Prerelease: []string{"0", "alpha", "0a"}, Build: []string{"001"}}))
+		for _, s := range []string{"", "1.2", "1.2.3.4", "1.2.3-", "a.b.c", "01.2.3", "1.02.3", "1.2.03", "1.2.3-01", "1.2.3-beta.00"} {
+			_, err = ParseSemVer(s)
+			Expect(err).To(HaveOccurred(), s)
+		}
+	})
+
+	It("should order versions by precedence", func() {
+		ordered := []string{
+			"1.0.0-0", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+			"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0",
+		}
+		var versions []SemVer
+		for i := len(ordered) - 1; i >= 0; i-- {
+			versions = append(versions, MustParseSemVer(ordered[i]))
+		}
+		SortSemVers(versions)
+		for i, v := range versions {
+			Expect(v.String()).To(Equal(ordered[i]))
+		}
+		Expect(MustParseSemVer("1.0.0+a").Compare(MustParseSemVer("1.0.0+b"))).To(Equal(0))
+	})
+
+	It("should unmarshal from JSON", func() {
+		var v struct{ Version SemVer }
+		Expect(json.Unmarshal([]byte(`{"Version":"2.1.0"}`), &v)).To(Succeed())
+		Expect(v.Version).To(Equal(MustParseSemVer("2.1.0")))
+		Expect(json.Unmarshal([]byte(`{"Version":"2.1"}`), &v)).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("VersionRange", func() {
+
+	cases := []struct {
+		r          string
+		match, not []string
+	}{
+		{"1.2.3", []string{"1.2.3", "v1.2.3+b"}, []string{"1.2.4", "1.2.3-beta"}},
+		{">1.2.3", []string{"1.2.4", "2.0.0"}, []string{"1.2.3", "1.2.4-beta"}},
+		{">= 1.2.3 <1.3.0", []string{"1.2.3", "1.2.9"}, []string{"1.3.0", "1.2.2"}},
+		{"<=1.2", []string{"1.2.9", "0.1.0"}, []string{"1.3.0", "1.3.0-0"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<1", []string{"0.9.9"}, []string{"1.0.0"}},
+		{"1.x", []string{"1.0.0", "1.9.9"}, []string{"2.0.0", "0.9.0", "2.0.0-alpha"}},
+		{"1.2.*", []string{"1.2.0", "1.2.9"}, []string{"1.3.0"}},
+		{"*", []string{"0.0.0", "9.9.9"}, []string{"1.0.0-beta"}},
+		{"", []string{"1.0.0"}, nil},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0", "1.2.2"}},
+		{"~1.2", []string{"1.2.0", "1.2.9"}, []string{"1.3.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"2.0.0", "1.2.2", "2.0.0-0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"^0.0", []string{"0.0.9"}, []string{"0.1.0"}},
+		{"^0.x", []string{"0.9.0"}, []string{"1.0.0"}},
+		{"^1.2.3-beta.2", []string{"1.2.3-beta.2", "1.2.3-beta.10", "1.2.3", "1.3.0"}, []string{"1.2.3-beta.1", "1.3.0-beta"}},
+		{"1.2.3 - 2.3", []string{"1.2.3", "2.3.9"}, []string{"2.4.0", "1.2.2"}},
+		{"1 - 2.3.4", []string{"1.0.0", "2.3.4"}, []string{"2.3.5"}},
+		{"1.x || >=3.1.0 <3.2.0", []string{"1.5.0", "3.1.5"}, []string{"2.0.0", "3.2.0"}},
+	}
+
+	It("should match the versions in each range", func() {
+		for _, c := range cases {
+			r, err := ParseVersionRange(c.r)
+			Expect(err).ToNot(HaveOccurred(), c.r)
+			for _, v := range c.match {
+				Expect(r.MatchString(v)).To(BeTrue(), "%q should match %q", c.r, v)
+			}
+			for _, v := range c.not {
+				Expect(r.MatchString(v)).To(BeFalse(), "%q should not match %q", c.r, v)
+			}
+		}
+	})
+
+	It("should reject invalid ranges", func() {
+		for _, s := range []string{"1.2.3.4", ">=a", "^1.2 - 2", "1.2.3 ||| 2", "^01.2", "~1.2.3-01"} {
+			_, err := ParseVersionRange(s)
+			Expect(err).To(HaveOccurred(), s)
+		}
+	})
+})
+
+var _ = Describe("Feature versions", func() {
+
+	var (
+		server *httptest.Server
+		client BaseClient
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/features":
+				json.NewEncoder(w).Encode([]map[string]interface{}{
+					{"name": "orders", "version": "1.2.0"},
+					{"name": "orders", "version": "1.10.0"},
+					{"name": "orders", "version": "2.0.0-beta.1"},
+					{"name": "orders", "version": "0.9.0"},
+				})
+			case "/api/features/instances":
+				json.NewEncoder(w).Encode([]map[string]interface{}{
+					{"featureName": "orders", "featureVersion": "1.2.0", "instanceName": "a"},
+					{"featureName": "orders", "featureVersion": "1.10.0", "instanceName": "b"},
+					{"featureName": "orders", "featureVersion": "1.11.0", "instanceName": "c"},
+					{"featureName": "orders", "featureVersion": "0.9.0", "instanceName": "d"},
+				})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		client = NewWithBaseURI(server.URL)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should find the latest feature in a range", func() {
+		f, err := client.LatestFeature(context.Background(), "orders", "^1.0.0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(to.String(f.Version)).To(Equal("1.10.0"))
+
+		f, err = client.LatestFeature(context.Background(), "orders", ">=2.0.0-beta <3")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(to.String(f.Version)).To(Equal("2.0.0-beta.1"))
+
+		_, err = client.LatestFeature(context.Background(), "orders", "^3")
+		Expect(err).To(MatchError(`no version of feature "orders" matches "^3"`))
+	})
+
+	It("should find the instances a version can serve", func() {
+		instances, err := client.InstancesCompatibleWith(context.Background(), "orders", MustParseSemVer("1.10.2"))
+		Expect(err).ToNot(HaveOccurred())
+		var names []string
+		for _, fi := range instances {
+			names = append(names, to.String(fi.InstanceName))
+		}
+		Expect(names).To(Equal([]string{"a", "b"}))
+	})
+})
@@ -0,0 +1,369 @@
+package beacon
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	getter "github.com/hashicorp/go-getter"
+)
+
+// ErrConfigNotModified is returned by a ConfigSource when it can tell
+// that the document has not changed since it was last fetched.
+var ErrConfigNotModified = errors.New("config not modified")
+
+// ConfigSource retrieves the FeatureInstance document monitored
+// by a FeatureInstanceMonitor.
+type ConfigSource interface {
+	// Fetch returns the FeatureInstance document. It may return
+	// ErrConfigNotModified if the document has not changed since
+	// the last successful Fetch.
+	Fetch(ctx context.Context) ([]byte, error)
+	// String describes the source in logs and errors.
+	String() string
+}
+
+// GetterSource is a ConfigSource which downloads the document using go-getter,
+// so it supports any URL which go-getter supports.
+type GetterSource struct {
+	URL string
+}
+
+func (g GetterSource) String() string {
+	return g.URL
+}
+
+// Fetch downloads the document into a temporary directory and returns its
+// contents. Downloads over http and https stop when ctx is done. Other
+// go-getter downloads can't be cancelled, so if ctx is done first Fetch
+// returns ctx's error and the download is discarded when it finishes.
+func (g GetterSource) Fetch(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("error getting config from %q: %s", g.URL, err)
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, err := g.get(ctx)
+		done <- result{data: data, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("error getting config from %q: %s", g.URL, ctx.Err())
+	}
+}
+
+func (g GetterSource) get(ctx context.Context) ([]byte, error) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "beacon")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// go-getter has no context, so its http getters use a client whose
+	// requests are made with ctx.
+	getters := make(map[string]getter.Getter, len(getter.Getters))
+	for scheme, gt := range getter.Getters {
+		getters[scheme] = gt
+	}
+	httpGetter := &getter.HttpGetter{
+		Netrc:  true,
+		Client: &http.Client{Transport: contextTransport{ctx: ctx, base: http.DefaultTransport}},
+	}
+	getters["http"], getters["https"] = httpGetter, httpGetter
+
+	filePath := filepath.Join(tmpDir, "featureInstance.json")
+	pwd, _ := filepath.Abs(".")
+	client := &getter.Client{
+		Src:     g.URL,
+		Pwd:     pwd,
+		Dst:     filePath,
+		Getters: getters,
+	}
+
+	err = client.Get()
+	if err != nil {
+		return nil, fmt.Errorf("error getting config from %q: %s", g.URL, err)
+	}
+
+	return ioutil.ReadFile(filePath)
+}
+
+// contextTransport makes requests with ctx.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// FileSource is a ConfigSource which reads the document from a local file.
+// Changes are detected by modification time and size, then by content hash.
+type FileSource struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	readAt  time.Time
+	hash    [sha256.Size]byte
+}
+
+// NewFileSource returns a FileSource which reads the file at path.
+func NewFileSource(path string) *FileSource {
+	return &FileSource{Path: path}
+}
+
+func (f *FileSource) String() string {
+	return f.Path
+}
+
+// Fetch reads the file, or returns ErrConfigNotModified if neither
+// its modification time nor its contents have changed.
+func (f *FileSource) Fetch(ctx context.Context) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error getting config from %q: %s", f.Path, err)
+	}
+	// The modification time can only be trusted if the file was last read
+	// well after it was modified, because the file could have been written
+	// again within the resolution of the filesystem's clock.
+	if !f.readAt.IsZero() && info.ModTime().Equal(f.modTime) && info.Size() == f.size &&
+		f.readAt.Sub(f.modTime) > time.Second {
+		return nil, ErrConfigNotModified
+	}
+
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error getting config from %q: %s", f.Path, err)
+	}
+
+	hash := sha256.Sum256(data)
+	unchanged := !f.readAt.IsZero() && hash == f.hash
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	f.readAt = time.Now()
+	f.hash = hash
+	if unchanged {
+		return nil, ErrConfigNotModified
+	}
+	return data, nil
+}
+
+// HTTPSource is a ConfigSource which gets the document from an HTTP(S) URL.
+// The ETag of each response is sent in If-None-Match on the next request,
+// so that servers can respond with 304 Not Modified.
+type HTTPSource struct {
+	URL string
+	// Client is used to send requests; http.DefaultClient is used if it is nil.
+	Client *http.Client
+	// Header is added to each request.
+	Header http.Header
+
+	mu   sync.Mutex
+	etag string
+}
+
+// NewHTTPSource returns an HTTPSource which gets the document from url.
+func NewHTTPSource(url string) *HTTPSource {
+	return &HTTPSource{URL: url}
+}
+
+func (h *HTTPSource) String() string {
+	return h.URL
+}
+
+// Fetch gets the document, or returns ErrConfigNotModified if
+// the server responds with 304 Not Modified.
+func (h *HTTPSource) Fetch(ctx context.Context) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range h.Header {
+		req.Header[k] = v
+	}
+	if h.etag != "" {
+		req.Header.Set("If-None-Match", h.etag)
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting config from %q: %s", h.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, ErrConfigNotModified
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error getting config from %q: %s", h.URL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting config from %q: %s: %s", h.URL, resp.Status, RedactString(string(bytes.TrimSpace(data))))
+	}
+
+	h.etag = resp.Header.Get("ETag")
+	return data, nil
+}
+
+// APISource is a ConfigSource which gets the FeatureInstance
+// from the Beacon API using its key.
+type APISource struct {
+	Client BaseClient
+	Key    string
+}
+
+func (a APISource) String() string {
+	return fmt.Sprintf("%s (key %s)", a.Client.BaseURI, Redacted)
+}
+
+// Fetch gets the FeatureInstance with the key and returns it serialized as JSON.
+func (a APISource) Fetch(ctx context.Context) ([]byte, error) {
+	featureInstance, err := a.Client.GetFeatureInstanceByKey(ctx, a.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting config from %s: %s", a, RedactString(err.Error()))
+	}
+	return json.Marshal(featureInstance)
+}
+
+// ConfigSourceFactory creates a ConfigSource from a URL.
+type ConfigSourceFactory func(u *url.URL) (ConfigSource, error)
+
+var (
+	configSourceMu        sync.Mutex
+	configSourceFactories = map[string]ConfigSourceFactory{
+		"file":         fileSourceFactory,
+		"http":         httpSourceFactory,
+		"https":        httpSourceFactory,
+		"beacon":       apiSourceFactory,
+		"beacon+http":  apiSourceFactory,
+		"beacon+https": apiSourceFactory,
+	}
+)
+
+// RegisterConfigSource registers a factory for ConfigSources with the given
+// URL scheme, for use by NewConfigSource. Registering a scheme again
+// replaces the previous factory.
+func RegisterConfigSource(scheme string, factory ConfigSourceFactory) {
+	configSourceMu.Lock()
+	defer configSourceMu.Unlock()
+	configSourceFactories[strings.ToLower(scheme)] = factory
+}
+
+// NewConfigSource returns the ConfigSource for a URL, typically taken from a
+// command line flag or environment variable:
+//
+//	/path/to/instance.json, file:///path/to/instance.json   FileSource
+//	http://host/instance.json, https://...                  HTTPSource
+//	beacon://host:port/<key>, beacon+https://host/<key>     APISource
+//
+// http and https URLs with go-getter's checksum or archive parameters, or
+// which name an archive, are downloaded by a GetterSource instead. APISources
+// use the token in the BEACON_TOKEN environment variable, if it is set.
+// Schemes added by RegisterConfigSource are also supported, and any other URL
+// is downloaded with go-getter by a GetterSource.
+func NewConfigSource(rawURL string) (ConfigSource, error) {
+	if u, err := url.Parse(rawURL); err == nil && u.Scheme != "" && !isWindowsDrive(u.Scheme) {
+		configSourceMu.Lock()
+		factory, ok := configSourceFactories[strings.ToLower(u.Scheme)]
+		configSourceMu.Unlock()
+		if ok {
+			return factory(u)
+		}
+		return GetterSource{URL: rawURL}, nil
+	}
+
+	if strings.Contains(rawURL, "::") {
+		// A go-getter forced getter, like git::https://...
+		return GetterSource{URL: rawURL}, nil
+	}
+
+	return NewFileSource(rawURL), nil
+}
+
+func isWindowsDrive(scheme string) bool {
+	return len(scheme) == 1
+}
+
+func fileSourceFactory(u *url.URL) (ConfigSource, error) {
+	path := u.Path
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, fmt.Errorf("file config source %q must not have a host", u)
+	}
+	return NewFileSource(path), nil
+}
+
+// httpSourceFactory returns an HTTPSource, unless the URL uses go-getter's
+// checksum or archive parameters or names an archive, in which case it is
+// downloaded by a GetterSource.
+func httpSourceFactory(u *url.URL) (ConfigSource, error) {
+	q := u.Query()
+	if q.Get("checksum") != "" || q.Get("archive") != "" {
+		return GetterSource{URL: u.String()}, nil
+	}
+	for ext := range getter.Decompressors {
+		if strings.HasSuffix(u.Path, "."+ext) {
+			return GetterSource{URL: u.String()}, nil
+		}
+	}
+	return NewHTTPSource(u.String()), nil
+}
+
+func apiSourceFactory(u *url.URL) (ConfigSource, error) {
+	key := strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		key = key[i+1:]
+	}
+	if key == "" {
+		return nil, fmt.Errorf("beacon config source %q must end with a feature instance key", u.Scheme+"://"+u.Host)
+	}
+
+	scheme := "http"
+	if u.Scheme == "beacon+https" {
+		scheme = "https"
+	}
+	basePath := strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/"+key)
+	baseURI := fmt.Sprintf("%s://%s%s", scheme, u.Host, basePath)
+
+	client := NewWithBaseURI(baseURI)
+	if token := os.Getenv("BEACON_TOKEN"); token != "" {
+		client = NewWithBaseURIAndAuth(baseURI, func() string { return token })
+	}
+
+	return APISource{Client: client, Key: key}, nil
+}
@@ -0,0 +1,115 @@
+package beacon_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("ConfigSource", func() {
+
+	ctx := context.Background()
+
+	It("should detect file changes", func() {
+		dir, err := ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "instance.json")
+		writeFeatureInstance(path, `{"instanceName":"a"}`)
+
+		sut := NewFileSource(path)
+		Expect(sut.Fetch(ctx)).To(Equal([]byte(`{"instanceName":"a"}`)))
+		_, err = sut.Fetch(ctx)
+		Expect(err).To(Equal(ErrConfigNotModified))
+
+		writeFeatureInstance(path, `{"instanceName":"b"}`)
+		Expect(sut.Fetch(ctx)).To(Equal([]byte(`{"instanceName":"b"}`)))
+	})
+
+	It("should use etags for http", func() {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			fmt.Fprint(w, `{"instanceName":"a"}`)
+		}))
+		defer server.Close()
+
+		sut := NewHTTPSource(server.URL)
+		Expect(sut.Fetch(ctx)).To(Equal([]byte(`{"instanceName":"a"}`)))
+		_, err := sut.Fetch(ctx)
+		Expect(err).To(Equal(ErrConfigNotModified))
+		Expect(requests).To(Equal(2))
+	})
+
+	It("should get feature instance from api by key", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/api/features/instances/secret-key"))
+			fmt.Fprint(w, `{"instanceName":"a","config":{"x":1}}`)
+		}))
+		defer server.Close()
+
+		u, _ := url.Parse(server.URL)
+		source, err := NewConfigSource("beacon://" + u.Host + "/secret-key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source.String()).ToNot(ContainSubstring("secret-key"))
+
+		monitor, err := NewFeatureInstanceMonitor(source)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*monitor.FeatureInstance().InstanceName).To(Equal("a"))
+	})
+
+	It("should cancel go-getter http downloads when the context is done", func() {
+		release := make(chan struct{})
+		cancelled := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+				close(cancelled)
+			case <-release:
+				fmt.Fprint(w, `{"instanceName":"a"}`)
+			}
+		}))
+		defer server.Close()
+		defer close(release)
+
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := GetterSource{URL: server.URL + "/instance.json"}.Fetch(timeout)
+		Expect(err).To(MatchError(ContainSubstring("context deadline exceeded")))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Eventually(cancelled).Should(BeClosed())
+	})
+
+	It("should resolve sources from urls", func() {
+		Expect(NewConfigSource("/tmp/instance.json")).To(BeAssignableToTypeOf(&FileSource{}))
+		Expect(NewConfigSource("file:///tmp/instance.json")).To(WithTransform(func(s ConfigSource) string {
+			return s.String()
+		}, Equal("/tmp/instance.json")))
+		Expect(NewConfigSource("https://example.com/instance.json")).To(BeAssignableToTypeOf(&HTTPSource{}))
+		Expect(NewConfigSource("https://example.com/instance.json?checksum=sha256:abc")).To(BeAssignableToTypeOf(GetterSource{}))
+		Expect(NewConfigSource("https://example.com/instance.tar.gz")).To(BeAssignableToTypeOf(GetterSource{}))
+		Expect(NewConfigSource("s3::https://s3.amazonaws.com/bucket/instance.json")).To(BeAssignableToTypeOf(GetterSource{}))
+
+		RegisterConfigSource("test", func(u *url.URL) (ConfigSource, error) {
+			return GetterSource{URL: "registered:" + u.Opaque}, nil
+		})
+		Expect(NewConfigSource("test:thing")).To(Equal(GetterSource{URL: "registered:thing"}))
+	})
+})
@@ -0,0 +1,111 @@
+package beacon
+
+// subscriber delivers the latest version of a monitored FeatureInstance to a
+// subscription. Changes only signal the subscriber; its goroutine reads the
+// latest version when it is ready to deliver, so a slow subscriber skips
+// intermediate versions instead of missing the latest one.
+type subscriber struct {
+	// key identifies the subscription for unsubscribing, if it is channel based.
+	key interface{}
+	// signal has a buffer of one, so that signals made while the
+	// subscriber is delivering a change are coalesced.
+	signal  chan struct{}
+	done    chan struct{}
+	deliver func(change ConfigChange, done <-chan struct{})
+	onClose func()
+}
+
+// notify tells the subscriber that there is a new version. It never blocks.
+func (sub *subscriber) notify() {
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+	}
+}
+
+// subscribe adds a subscriber which will invoke deliver with each new version,
+// starting with the current one, and onClose (if set) when it is removed.
+func (s *FeatureInstanceMonitor) subscribe(key interface{}, deliver func(ConfigChange, <-chan struct{}), onClose func()) *subscriber {
+	sub := &subscriber{
+		key:     key,
+		signal:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		deliver: deliver,
+		onClose: onClose,
+	}
+
+	s.mu.Lock()
+	s.subscribers[sub] = true
+	s.mu.Unlock()
+
+	sub.notify()
+	go s.runSubscriber(sub)
+
+	return sub
+}
+
+func (s *FeatureInstanceMonitor) runSubscriber(sub *subscriber) {
+	if sub.onClose != nil {
+		defer sub.onClose()
+	}
+
+	var (
+		last        FeatureInstance
+		lastVersion uint64
+		delivered   bool
+	)
+
+	for {
+		select {
+		case <-sub.done:
+			return
+		case <-sub.signal:
+		}
+
+		current, version := s.Current()
+		if delivered && version == lastVersion {
+			continue
+		}
+
+		change := NewConfigChange(last, current)
+		change.Version = version
+		sub.deliver(change, sub.done)
+
+		last, lastVersion, delivered = current, version, true
+	}
+}
+
+// unsubscribe removes the channel based subscriber with the given key.
+func (s *FeatureInstanceMonitor) unsubscribe(key interface{}) {
+	s.mu.Lock()
+	var found *subscriber
+	for sub := range s.subscribers {
+		if sub.key == key {
+			found = sub
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if found != nil {
+		s.removeSubscriber(found)
+	}
+}
+
+func (s *FeatureInstanceMonitor) removeSubscriber(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers[sub] {
+		delete(s.subscribers, sub)
+		close(sub.done)
+	}
+}
+
+func (s *FeatureInstanceMonitor) closeSubscribers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subscribers {
+		delete(s.subscribers, sub)
+		close(sub.done)
+	}
+}
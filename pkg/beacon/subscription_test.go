@@ -0,0 +1,132 @@
+package beacon_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/naveego/beacon-go/pkg/beacon"
+)
+
+var _ = Describe("FeatureInstanceMonitor subscriptions", func() {
+
+	var (
+		dir     string
+		path    string
+		monitor *FeatureInstanceMonitor
+	)
+
+	write := func(n int) {
+		writeFeatureInstance(path, fmt.Sprintf(`{"config":{"n":%d}}`, n))
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "beacon-test")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "instance.json")
+		write(0)
+		monitor, err = NewFeatureInstanceMonitorFromURL(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(monitor.Version()).To(Equal(uint64(1)))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should eventually deliver the latest version to a slow subscriber", func() {
+		c := monitor.Subscribe()
+		defer monitor.Unsubscribe(c)
+
+		for i := 1; i <= 5; i++ {
+			write(i)
+			Expect(monitor.Refresh()).To(BeTrue())
+		}
+		Expect(monitor.Version()).To(Equal(uint64(6)))
+
+		var latest FeatureInstance
+		Eventually(func() interface{} {
+			select {
+			case latest = <-c:
+			default:
+			}
+			return latest.Config
+		}).Should(HaveKeyWithValue("n", float64(5)))
+	})
+
+	It("should describe changes from the last delivered version", func() {
+		c := monitor.SubscribeChanges()
+		var first ConfigChange
+		Eventually(c).Should(Receive(&first))
+		Expect(first.Version).To(Equal(uint64(1)))
+
+		write(1)
+		monitor.Refresh()
+		write(2)
+		monitor.Refresh()
+
+		var change ConfigChange
+		Eventually(c).Should(Receive(&change))
+		if change.Version == 2 {
+			Eventually(c).Should(Receive(&change))
+		}
+		Expect(change.Version).To(Equal(uint64(3)))
+		Expect(change.Paths).To(Equal([]string{"config.n"}))
+		Expect(change.New.Config).To(HaveKeyWithValue("n", float64(2)))
+
+		monitor.UnsubscribeChanges(c)
+		Eventually(c).Should(BeClosed())
+	})
+
+	It("should invoke callbacks sequentially", func() {
+		var (
+			mu       sync.Mutex
+			versions []uint64
+		)
+		stop := monitor.OnChange(func(change ConfigChange) {
+			mu.Lock()
+			versions = append(versions, change.Version)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+		})
+		defer stop()
+
+		for i := 1; i <= 3; i++ {
+			write(i)
+			monitor.Refresh()
+		}
+
+		Eventually(func() uint64 {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(versions) == 0 {
+				return 0
+			}
+			return versions[len(versions)-1]
+		}).Should(Equal(uint64(4)))
+		mu.Lock()
+		defer mu.Unlock()
+		for i := 1; i < len(versions); i++ {
+			Expect(versions[i]).To(BeNumerically(">", versions[i-1]))
+		}
+	})
+
+	It("should notify errors", func() {
+		errs := make(chan error, 1)
+		stop := monitor.OnError(func(err error) { errs <- err })
+		defer stop()
+
+		writeFeatureInstance(path, `not json`)
+		_, err := monitor.Refresh()
+		Expect(err).To(HaveOccurred())
+		Expect(errs).To(Receive(Equal(err)))
+		Expect(monitor.Version()).To(Equal(uint64(1)))
+	})
+})
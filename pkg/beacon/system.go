@@ -2,6 +2,7 @@ package beacon
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/Azure/go-autorest/autorest/to"
@@ -21,11 +22,30 @@ type HasExpectation interface {
 }
 
 type SystemOptions struct {
-	Name                string
-	Tenant              string
-	DisplayName         string
-	Description         string
+	Name        string
+	Tenant      string
+	DisplayName string
+	Description string
+	// FeatureInstancePath - The NRN path of the feature instance the system implements.
 	FeatureInstancePath string
+	// FeatureInstanceNRN - The feature instance the system implements, as
+	// an NRN. Takes precedence over FeatureInstancePath if set.
+	FeatureInstanceNRN NRN
+	// ConfigMonitor - If set, the system reports the config the monitor has
+	// applied as ActiveConfig, see ConfigHash. The API can't update a system,
+	// so when the monitor applies a change the system is deleted and created
+	// again with the new ActiveConfig, until it is shut down. Its children
+	// and expectations are not re-created.
+	ConfigMonitor *FeatureInstanceMonitor
+}
+
+// featureInstancePath returns the path of the feature instance, preferring
+// FeatureInstanceNRN over FeatureInstancePath.
+func (o SystemOptions) featureInstancePath() string {
+	if !o.FeatureInstanceNRN.IsZero() {
+		return o.FeatureInstanceNRN.String()
+	}
+	return o.FeatureInstancePath
 }
 
 type ExpectationOptions struct {
@@ -61,57 +81,82 @@ type RunningExpectation interface {
 
 type runningSystem struct {
 	nrn    NRN
-	log    Log
+	log    ScopedLog
 	client *BaseClient
-	system *System
+	// inputs created the system.
+	inputs *SystemInputs
+	// stopWatching stops watching the monitor the system reports its
+	// ActiveConfig from, if any.
+	stopWatching func()
+
+	// mu guards system, which is replaced when the system is re-created to
+	// report its ActiveConfig, and deleted, which is true if re-creating it
+	// failed after it was deleted.
+	mu      sync.Mutex
+	system  *System
+	deleted bool
 }
 
 func (d *runningSystem) System() *System {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	return d.system
 }
 func (d *runningSystem) Child(options SystemOptions) RunningSystem {
-	d.log.Debug(d.nrn, "Creating child system.", map[string]interface{}{"options": options})
+	d.log.Debug("Creating child system.", map[string]interface{}{"options": options})
+	parent := d.System()
 	nrn := d.nrn.ChildSystem(options.Name)
+	log := d.log.Scope(nrn).With(map[string]interface{}{"options": options})
 	inputs := &SystemInputs{
 		Name:                to.StringPtr(options.Name),
-		Tenant:              stringPtrOrNil(options.Tenant, *d.system.Tenant),
+		Tenant:              stringPtrOrNil(options.Tenant, *parent.Tenant),
 		Description:         stringPtrOrNil(options.Description),
 		DisplayName:         stringPtrOrNil(options.DisplayName),
 		ParentPath:          to.StringPtr(d.nrn.String()),
-		FeatureInstancePath: stringPtrOrNil(options.FeatureInstancePath),
+		FeatureInstancePath: stringPtrOrNil(options.featureInstancePath()),
 	}
 	if inputs.FeatureInstancePath == nil {
-		inputs.FeatureInstancePath = d.system.FeatureInstancePath
+		inputs.FeatureInstancePath = parent.FeatureInstancePath
+	}
+	if options.ConfigMonitor != nil {
+		inputs.ActiveConfig = stringPtrOrNil(activeConfig(options.ConfigMonitor, log))
 	}
 
 	system, err := d.client.CreateSystem(timeoutCtx(), inputs)
 
 	if err != nil {
-		d.log.Warn(d.nrn, "Could not start system. Dummy system will be used instead.", map[string]interface{}{"error": err.Error()})
+		d.log.Warn("Could not start system. Dummy system will be used instead.", map[string]interface{}{"error": err.Error()})
 
 		return &dummySystem{
 			nrn: nrn,
-			log: d.log,
+			log: log,
 		}
 	}
 
-	d.log.Debug(nrn, "Started system.")
+	log.Debug("Started system.")
 
-	return &runningSystem{
+	child := &runningSystem{
 		nrn:    nrn,
 		system: &system,
 		client: d.client,
-		log:    d.log,
+		log:    log,
+		inputs: inputs,
 	}
+	if options.ConfigMonitor != nil {
+		child.stopWatching = child.watchActiveConfig(options.ConfigMonitor)
+	}
+	return child
 }
 func (d *runningSystem) Expectation(options ExpectationOptions) RunningExpectation {
-	d.log.Debug(d.nrn, "Creating expectation.", map[string]interface{}{"options": options})
+	d.log.Debug("Creating expectation.", map[string]interface{}{"options": options})
 	nrn := d.nrn.ChildExpectation(options.Name)
+	log := d.log.Scope(nrn).With(map[string]interface{}{"options": options})
+	system := d.System()
 
 	inputs := &ExpectationInputs{
 		Name:        to.StringPtr(options.Name),
-		Tenant:      d.system.Tenant,
-		System:      d.system.Path,
+		Tenant:      system.Tenant,
+		System:      system.Path,
 		DisplayName: stringPtrOrNil(options.DisplayName),
 		Description: stringPtrOrNil(options.Description),
 		Behavior:    options.Behavior,
@@ -127,10 +172,10 @@ func (d *runningSystem) Expectation(options ExpectationOptions) RunningExpectati
 
 	expectation, err := d.client.CreateExpectation(timeoutCtx(), inputs)
 	if err != nil {
-		d.log.Warn(d.nrn, "Could not start system. Dummy expectation will be used instead.", map[string]interface{}{"error": err.Error()})
+		d.log.Warn("Could not start system. Dummy expectation will be used instead.", map[string]interface{}{"error": err.Error()})
 		return &dummyExpectation{
 			nrn: nrn,
-			log: d.log,
+			log: log,
 		}
 	}
 
@@ -138,31 +183,43 @@ func (d *runningSystem) Expectation(options ExpectationOptions) RunningExpectati
 		nrn:         nrn,
 		expectation: &expectation,
 		client:      d.client,
-		log:         d.log,
+		log:         log,
 	}
 }
 
 func (d *runningSystem) Shutdown() {
-	_, err := d.client.DeleteSystem(timeoutCtx(), to.String(d.system.Path))
-	if err != nil {
-		d.log.Warn(d.nrn, "Shutdown failed.", map[string]interface{}{"error": err.Error()})
+	if d.stopWatching != nil {
+		d.stopWatching()
 	}
-	d.log.Debug(d.nrn, "Shutdown.")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.deleted {
+		_, err := d.client.DeleteSystem(timeoutCtx(), to.String(d.system.Path))
+		if err != nil {
+			d.log.Warn("Shutdown failed.", map[string]interface{}{"error": err.Error()})
+		}
+	}
+	d.log.Debug("Shutdown.")
 }
 
+// StartSystem starts a system implementing a feature instance. If the system
+// cannot be started a dummy system which only logs is returned instead.
+// Messages written to log have secrets redacted, see NewRedactingLog.
 func (c *BaseClient) StartSystem(options SystemOptions, log Log) RunningSystem {
 
-	featureInstanceNRN, err := ParseNRN(options.FeatureInstancePath)
+	log = NewRedactingLog(log)
+
+	featureInstanceNRN, err := ParseNRN(options.featureInstancePath())
 	if err != nil {
 		log.Warn(featureInstanceNRN, "Invalid feature instance NRN.", map[string]interface{}{"error": err.Error()})
 		return &dummySystem{
 			nrn: featureInstanceNRN,
-			log: log,
+			log: NewScopedLog(log, featureInstanceNRN),
 		}
 	}
 
 	tempParentSystem := &runningSystem{
-		log: log,
+		log: NewScopedLog(log, featureInstanceNRN),
 		nrn: featureInstanceNRN,
 		system: &System{
 			FeatureInstancePath: to.StringPtr(featureInstanceNRN.String()),
@@ -0,0 +1,75 @@
+package beacon
+
+import (
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseTOML parses a TOML document into the map shapes produced by
+// json.Unmarshal, except that integers are int64. Dates and times are
+// returned as strings.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	var table map[string]interface{}
+	if _, err := toml.Decode(string(data), &table); err != nil {
+		return nil, tomlDocumentError(data, err)
+	}
+	return tomlTimesToStrings(table).(map[string]interface{}), nil
+}
+
+// tomlTimesToStrings replaces the time.Time values of dates and times with
+// the strings they were written as. Local dates and times are decoded in
+// locations named for their TOML types.
+func tomlTimesToStrings(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			t[k] = tomlTimesToStrings(child)
+		}
+	case []map[string]interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			out[i] = tomlTimesToStrings(child)
+		}
+		return out
+	case []interface{}:
+		for i, child := range t {
+			t[i] = tomlTimesToStrings(child)
+		}
+	case time.Time:
+		switch t.Location().String() {
+		case "datetime-local":
+			return t.Format("2006-01-02T15:04:05.999999999")
+		case "date-local":
+			return t.Format("2006-01-02")
+		case "time-local":
+			return t.Format("15:04:05.999999999")
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// tomlDocumentError converts a TOML error to a *DocumentError at the
+// position the parser reports.
+func tomlDocumentError(data []byte, err error) error {
+	var pe toml.ParseError
+	switch t := err.(type) {
+	case toml.ParseError:
+		pe = t
+	case *toml.ParseError:
+		pe = *t
+	default:
+		return &DocumentError{Format: FormatTOML, Message: strings.TrimPrefix(err.Error(), "toml: ")}
+	}
+	message := pe.Message
+	if message == "" {
+		message = strings.TrimPrefix(err.Error(), "toml: ")
+		if i := strings.Index(message, ": "); strings.HasPrefix(message, "line ") && i >= 0 {
+			message = message[i+2:]
+		}
+	}
+	line, column := lineAndColumn(string(data), pe.Position.Start)
+	return &DocumentError{Format: FormatTOML, Line: line, Column: column, Message: message}
+}
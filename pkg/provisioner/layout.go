@@ -0,0 +1,333 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/naveego/beacon-go/pkg/beacon"
+)
+
+// Types of LayoutNode.
+const (
+	NodeFile    = "file"
+	NodeDir     = "dir"
+	NodeSymlink = "symlink"
+)
+
+// node is a rendered LayoutNode.
+type node struct {
+	// Path is relative to the directory the layout is rendered into,
+	// and uses forward slashes.
+	Path string
+	Type string
+	// Content is the content of a file, or the target of a symlink.
+	Content string
+}
+
+// RenderLayout renders layout into the directory set in Options.Dir,
+// joined with layout.Path if it is set.
+//
+// The PathTemplate and ContentTemplate of each node are text/template
+// templates executed with data; referring to a missing key is an error, so
+// optional values should be looked up with index. A node's Type is "file"
+// (the default), "dir" or "symlink", whose ContentTemplate renders the link's
+// target relative to the link. Paths and symlink targets must stay within
+// the directory, and nodes are not written through symlinked directories,
+// including those in layout.Path; Options.Dir itself may be a symlink.
+// Nodes whose rendered path matches layout.Include, if it is set, and doesn't
+// match layout.Exclude are written; both are comma-separated lists of
+// slash-separated globs in which "**" matches any number of directories.
+// All nodes are rendered before any are written.
+func (p *Provisioner) RenderLayout(ctx context.Context, layout beacon.Layout, data TemplateData) error {
+	root, layoutPath, nodes, err := p.renderLayout(layout, data)
+	if err != nil {
+		return err
+	}
+	if err = checkParents(p.options.Dir, layoutPath+"/"); err != nil {
+		return err
+	}
+
+	log := p.logger(data.Instance.Path)
+	for _, n := range nodes {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if err = writeNode(root, n); err != nil {
+			return fmt.Errorf("error writing %s: %s", n.Path, err)
+		}
+		log.Debug("wrote layout node", map[string]interface{}{"path": n.Path, "type": n.Type})
+	}
+	return nil
+}
+
+// renderLayout returns the directory layout is rendered into, its rendered
+// layout.Path, which is "." if it isn't set, and its nodes.
+func (p *Provisioner) renderLayout(layout beacon.Layout, data TemplateData) (string, string, []node, error) {
+	if !filepath.IsAbs(p.options.Dir) {
+		return "", "", nil, fmt.Errorf("provisioner Dir must be an absolute path, got %q", p.options.Dir)
+	}
+	layoutPath := "."
+	if text := to.String(layout.Path); text != "" {
+		rendered, err := renderTemplate("layout path", text, data)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if layoutPath, err = cleanPath(rendered); err != nil {
+			return "", "", nil, fmt.Errorf("layout path: %s", err)
+		}
+	}
+	root := filepath.Join(p.options.Dir, filepath.FromSlash(layoutPath))
+
+	include, err := compileGlobs(to.String(layout.Include))
+	if err != nil {
+		return "", "", nil, fmt.Errorf("invalid include: %s", err)
+	}
+	exclude, err := compileGlobs(to.String(layout.Exclude))
+	if err != nil {
+		return "", "", nil, fmt.Errorf("invalid exclude: %s", err)
+	}
+
+	if layout.Nodes == nil {
+		return root, layoutPath, nil, nil
+	}
+	var nodes []node
+	for i, ln := range *layout.Nodes {
+		n, err := renderNode(ln, data)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("node %d: %s", i, err)
+		}
+		if len(include) > 0 && !matchAny(include, n.Path) || matchAny(exclude, n.Path) {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return root, layoutPath, nodes, nil
+}
+
+func renderNode(ln beacon.LayoutNode, data TemplateData) (node, error) {
+	n := node{Type: to.String(ln.Type)}
+	switch n.Type {
+	case "":
+		n.Type = NodeFile
+	case NodeFile, NodeDir, NodeSymlink:
+	default:
+		return n, fmt.Errorf("unknown type %q", n.Type)
+	}
+
+	rendered, err := renderTemplate("path", to.String(ln.PathTemplate), data)
+	if err != nil {
+		return n, err
+	}
+	if n.Path, err = cleanPath(rendered); err != nil {
+		return n, err
+	}
+	if n.Type == NodeDir {
+		return n, nil
+	}
+	if n.Content, err = renderTemplate(n.Path, to.String(ln.ContentTemplate), data); err != nil {
+		return n, err
+	}
+	if n.Type == NodeSymlink {
+		if n.Content == "" {
+			return n, fmt.Errorf("symlink %s has no target", n.Path)
+		}
+		// The target is relative to the symlink's directory, and must
+		// also stay within the layout.
+		target := filepath.ToSlash(n.Content)
+		if path.IsAbs(target) || filepath.IsAbs(n.Content) {
+			return n, fmt.Errorf("symlink %s target %q is outside the layout", n.Path, n.Content)
+		}
+		if _, err = cleanPath(path.Join(path.Dir(n.Path), target)); err != nil {
+			return n, fmt.Errorf("symlink %s target %q is outside the layout", n.Path, n.Content)
+		}
+	}
+	return n, nil
+}
+
+var templateFuncs = template.FuncMap{
+	// json renders v as JSON.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// default returns v, or def if v is empty.
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" || v == false {
+			return def
+		}
+		return v
+	},
+}
+
+func renderTemplate(name, text string, data TemplateData) (string, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// cleanPath cleans a rendered path, which must be relative and stay
+// within the directory the layout is rendered into.
+func cleanPath(p string) (string, error) {
+	p = strings.TrimSpace(filepath.ToSlash(p))
+	if p == "" {
+		return "", fmt.Errorf("path is empty")
+	}
+	if path.IsAbs(p) || filepath.IsAbs(p) {
+		return "", fmt.Errorf("path %q is not relative", p)
+	}
+	clean := path.Clean(p)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("path %q is outside the layout", p)
+	}
+	return clean, nil
+}
+
+// compileGlobs compiles a comma-separated list of globs.
+func compileGlobs(patterns string) ([]*regexp.Regexp, error) {
+	var globs []*regexp.Regexp
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(globToRegexp(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", pattern, err)
+		}
+		globs = append(globs, re)
+	}
+	return globs, nil
+}
+
+// globToRegexp converts a glob in which "*" and "?" don't match "/",
+// "**" matches anything and "[...]" is a character class, to a regexp.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+func matchAny(globs []*regexp.Regexp, p string) bool {
+	for _, g := range globs {
+		if g.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkParents returns an error if a directory between root and the node
+// at path is a symlink, so that writes can't be redirected outside root. A
+// path ending in "/" is a directory, which is checked too.
+func checkParents(root, path string) error {
+	dir := root
+	parts := strings.Split(path, "/")
+	for _, part := range parts[:len(parts)-1] {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", dir)
+		}
+	}
+	return nil
+}
+
+// writeNode writes n beneath root, replacing whatever was at its path.
+func writeNode(root string, n node) error {
+	if err := checkParents(root, n.Path); err != nil {
+		return err
+	}
+	target := filepath.Join(root, filepath.FromSlash(n.Path))
+	if n.Type == NodeDir {
+		return os.MkdirAll(target, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(target); err == nil && info.IsDir() {
+		return fmt.Errorf("%s is a directory", target)
+	}
+
+	if n.Type == NodeSymlink {
+		if current, err := os.Readlink(target); err == nil && current == n.Content {
+			return nil
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(n.Content, target)
+	}
+
+	// Write to a temporary file and rename it, so that readers never see a
+	// partial file and an existing symlink is replaced rather than followed.
+	f, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.WriteString(n.Content); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), target)
+}
@@ -0,0 +1,266 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/naveego/beacon-go/pkg/beacon"
+)
+
+// ActionType is the type of change an Action makes.
+type ActionType string
+
+// Types of Action.
+const (
+	ActionCreate ActionType = "create"
+	ActionModify ActionType = "modify"
+	ActionDelete ActionType = "delete"
+)
+
+// Action is a change a Plan makes to the filesystem.
+type Action struct {
+	Type ActionType
+	// Path is relative to Plan.Dir, and uses forward slashes.
+	Path string
+	// NodeType is the type of the node created, modified or deleted.
+	NodeType string
+	// Diff is the unified diff of the content of a file, or the target of a
+	// symlink. It is empty for directories.
+	Diff string
+}
+
+// Plan contains the changes rendering a layout would make.
+type Plan struct {
+	// Dir is the directory the layout is rendered into.
+	Dir string
+	// Actions are sorted by path, except that a node replaced by one of a
+	// different type is deleted before it is created.
+	Actions []Action
+
+	nodes        []node
+	instancePath string
+	// base is Options.Dir, and layoutPath is the path of Dir beneath it.
+	base, layoutPath string
+	// states are the states of the paths of nodes when the plan was made.
+	states map[string]string
+}
+
+// PlanStaleError is returned by ApplyPlan when the filesystem changed
+// after the plan was made.
+type PlanStaleError struct {
+	// Paths are the paths which changed, relative to the plan's Dir.
+	Paths []string
+}
+
+func (e *PlanStaleError) Error() string {
+	return fmt.Sprintf("plan is stale, because these paths changed since it was made: %s", strings.Join(e.Paths, ", "))
+}
+
+// PlanLayout returns the changes RenderLayout would make, without making
+// them. It returns an error if a node would replace a directory.
+func (p *Provisioner) PlanLayout(layout beacon.Layout, data TemplateData) (*Plan, error) {
+	root, layoutPath, nodes, err := p.renderLayout(layout, data)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Dir:          root,
+		nodes:        nodes,
+		instancePath: data.Instance.Path,
+		base:         p.options.Dir,
+		layoutPath:   layoutPath,
+		states:       make(map[string]string),
+	}
+	if err = checkParents(plan.base, plan.layoutPath+"/"); err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		if err = checkParents(root, n.Path); err != nil {
+			return nil, err
+		}
+		current, err := readNode(root, n.Path)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil && current.Type == NodeDir && n.Type != NodeDir {
+			// Directories aren't replaced, as RenderLayout would fail to
+			// replace them and their contents aren't in the plan.
+			return nil, fmt.Errorf("%s is a directory, which cannot be replaced by a %s", n.Path, n.Type)
+		}
+		plan.states[n.Path] = current.state()
+		plan.Actions = append(plan.Actions, planNode(current, n)...)
+	}
+	sort.SliceStable(plan.Actions, func(i, j int) bool {
+		return plan.Actions[i].Path < plan.Actions[j].Path
+	})
+	return plan, nil
+}
+
+// ApplyPlan makes the changes in plan. If any of the paths of the layout
+// changed after the plan was made it returns a *PlanStaleError without
+// changing anything.
+func (p *Provisioner) ApplyPlan(ctx context.Context, plan *Plan) error {
+	if err := checkParents(plan.base, plan.layoutPath+"/"); err != nil {
+		return err
+	}
+	var stale []string
+	for _, n := range plan.nodes {
+		if err := checkParents(plan.Dir, n.Path); err != nil {
+			return err
+		}
+		current, err := readNode(plan.Dir, n.Path)
+		if err != nil {
+			return err
+		}
+		if current.state() != plan.states[n.Path] {
+			stale = append(stale, n.Path)
+		}
+	}
+	if len(stale) > 0 {
+		return &PlanStaleError{Paths: stale}
+	}
+
+	log := p.logger(plan.instancePath)
+	for _, action := range plan.Actions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		if action.Type == ActionDelete {
+			err = removeNode(plan.Dir, action.Path)
+		} else {
+			err = writeNode(plan.Dir, plan.node(action.Path))
+		}
+		if err != nil {
+			return fmt.Errorf("error applying %s of %s: %s", action.Type, action.Path, err)
+		}
+		log.Debug("applied layout action", map[string]interface{}{"action": string(action.Type), "path": action.Path})
+	}
+	return nil
+}
+
+// HasChanges returns true if the plan contains any actions.
+func (plan *Plan) HasChanges() bool {
+	return len(plan.Actions) > 0
+}
+
+// String returns a human-readable description of the plan, with the
+// diff of each action.
+func (plan *Plan) String() string {
+	var buf bytes.Buffer
+	counts := make(map[ActionType]int)
+	for _, action := range plan.Actions {
+		counts[action.Type]++
+		symbol := map[ActionType]string{ActionCreate: "+", ActionModify: "~", ActionDelete: "-"}[action.Type]
+		fmt.Fprintf(&buf, "%s %s %s %s\n", symbol, action.Type, action.NodeType, action.Path)
+		buf.WriteString(action.Diff)
+	}
+	fmt.Fprintf(&buf, "Plan: %d to create, %d to modify, %d to delete in %s.\n",
+		counts[ActionCreate], counts[ActionModify], counts[ActionDelete], plan.Dir)
+	return buf.String()
+}
+
+func (plan *Plan) node(path string) node {
+	for _, n := range plan.nodes {
+		if n.Path == path {
+			return n
+		}
+	}
+	return node{}
+}
+
+// planNode returns the actions which change current into n.
+func planNode(current *node, n node) []Action {
+	if current == nil {
+		return []Action{{Type: ActionCreate, Path: n.Path, NodeType: n.Type, Diff: nodeDiff(nil, &n)}}
+	}
+	if current.Type != n.Type {
+		return []Action{
+			{Type: ActionDelete, Path: n.Path, NodeType: current.Type, Diff: nodeDiff(current, nil)},
+			{Type: ActionCreate, Path: n.Path, NodeType: n.Type, Diff: nodeDiff(nil, &n)},
+		}
+	}
+	if current.Content != n.Content {
+		return []Action{{Type: ActionModify, Path: n.Path, NodeType: n.Type, Diff: nodeDiff(current, &n)}}
+	}
+	return nil
+}
+
+func nodeDiff(from, to *node) string {
+	if (from != nil && from.Type == NodeDir) || (to != nil && to.Type == NodeDir) {
+		return ""
+	}
+	fromName, toName := devNull, devNull
+	var a, b string
+	if from != nil {
+		fromName, a = "a/"+from.Path, diffContent(*from)
+	}
+	if to != nil {
+		toName, b = "b/"+to.Path, diffContent(*to)
+	}
+	return unifiedDiff(fromName, toName, a, b)
+}
+
+// diffContent returns the content of a node to diff; a symlink's target
+// is shown as a line.
+func diffContent(n node) string {
+	if n.Type == NodeSymlink {
+		return n.Content + "\n"
+	}
+	return n.Content
+}
+
+// removeNode removes the file or symlink at path beneath root.
+func removeNode(root, path string) error {
+	if err := checkParents(root, path); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(root, filepath.FromSlash(path)))
+}
+
+// readNode returns the node at path beneath root, or nil if there is none.
+func readNode(root, path string) (*node, error) {
+	target := filepath.Join(root, filepath.FromSlash(path))
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n := &node{Path: path}
+	switch {
+	case info.IsDir():
+		n.Type = NodeDir
+	case info.Mode()&os.ModeSymlink != 0:
+		n.Type = NodeSymlink
+		if n.Content, err = os.Readlink(target); err != nil {
+			return nil, err
+		}
+	default:
+		n.Type = NodeFile
+		b, err := ioutil.ReadFile(target)
+		if err != nil {
+			return nil, err
+		}
+		n.Content = string(b)
+	}
+	return n, nil
+}
+
+// state returns a string which changes when the node changes.
+func (n *node) state() string {
+	if n == nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%s:%x", n.Type, sha256.Sum256([]byte(n.Content)))
+}
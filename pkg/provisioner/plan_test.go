@@ -0,0 +1,138 @@
+package provisioner_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/naveego/beacon-go/pkg/beacon"
+	. "github.com/naveego/beacon-go/pkg/provisioner"
+)
+
+var _ = Describe("Plan", func() {
+
+	var (
+		dir    string
+		p      *Provisioner
+		layout beacon.Layout
+		data   TemplateData
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "provisioner-test")
+		Expect(err).ToNot(HaveOccurred())
+		p = New(Options{Dir: dir})
+
+		layout = beacon.Layout{Nodes: &[]beacon.LayoutNode{
+			layoutNode("", "app.conf", "a\nb\nc\nd\ne\nf\ng\nh\ni\nport={{.Config.port}}\n"),
+			layoutNode("dir", "data", ""),
+			layoutNode("symlink", "current", "app.conf"),
+			layoutNode("", "new.txt", "hello"),
+		}}
+		data = TemplateData{Config: map[string]interface{}{"port": 8080}}
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "app.conf"), []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nport=80\n"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "current"), []byte("not a link\n"), 0644)).To(Succeed())
+		Expect(os.Mkdir(filepath.Join(dir, "data"), 0755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should plan changes without making them", func() {
+		plan, err := p.PlanLayout(layout, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.HasChanges()).To(BeTrue())
+
+		Expect(plan.Actions).To(Equal([]Action{
+			{Type: ActionModify, Path: "app.conf", NodeType: NodeFile, Diff: "--- a/app.conf\n+++ b/app.conf\n" +
+				"@@ -7,4 +7,4 @@\n g\n h\n i\n-port=80\n+port=8080\n"},
+			{Type: ActionDelete, Path: "current", NodeType: NodeFile, Diff: "--- a/current\n+++ /dev/null\n" +
+				"@@ -1 +0,0 @@\n-not a link\n"},
+			{Type: ActionCreate, Path: "current", NodeType: NodeSymlink, Diff: "--- /dev/null\n+++ b/current\n" +
+				"@@ -0,0 +1 @@\n+app.conf\n"},
+			{Type: ActionCreate, Path: "new.txt", NodeType: NodeFile, Diff: "--- /dev/null\n+++ b/new.txt\n" +
+				"@@ -0,0 +1 @@\n+hello\n\\ No newline at end of file\n"},
+		}))
+		Expect(plan.String()).To(HavePrefix("~ modify file app.conf\n--- a/app.conf\n"))
+		Expect(plan.String()).To(ContainSubstring("- delete file current\n"))
+		Expect(plan.String()).To(HaveSuffix("Plan: 2 to create, 1 to modify, 1 to delete in " + dir + ".\n"))
+
+		Expect(readFile(filepath.Join(dir, "app.conf"))).To(ContainSubstring("port=80\n"))
+		Expect(filepath.Join(dir, "new.txt")).ToNot(BeAnExistingFile())
+
+		Expect(p.ApplyPlan(context.Background(), plan)).To(Succeed())
+		Expect(readFile(filepath.Join(dir, "app.conf"))).To(ContainSubstring("port=8080\n"))
+		Expect(os.Readlink(filepath.Join(dir, "current"))).To(Equal("app.conf"))
+		Expect(readFile(filepath.Join(dir, "new.txt"))).To(Equal("hello"))
+
+		plan, err = p.PlanLayout(layout, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.HasChanges()).To(BeFalse())
+		Expect(plan.String()).To(Equal("Plan: 0 to create, 0 to modify, 0 to delete in " + dir + ".\n"))
+	})
+
+	It("should not apply a plan if the filesystem changed", func() {
+		plan, err := p.PlanLayout(layout, data)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "new.txt"), []byte("changed"), 0644)).To(Succeed())
+		err = p.ApplyPlan(context.Background(), plan)
+		Expect(err).To(BeAssignableToTypeOf(&PlanStaleError{}))
+		Expect(err.(*PlanStaleError).Paths).To(Equal([]string{"new.txt"}))
+		Expect(readFile(filepath.Join(dir, "app.conf"))).To(ContainSubstring("port=80\n"))
+	})
+
+	It("should not plan to replace a directory", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "data", "keep"), []byte("x"), 0644)).To(Succeed())
+		layout.Nodes = &[]beacon.LayoutNode{layoutNode("symlink", "data", "app.conf")}
+		_, err := p.PlanLayout(layout, data)
+		Expect(err).To(MatchError("data is a directory, which cannot be replaced by a symlink"))
+		Expect(filepath.Join(dir, "data", "keep")).To(BeAnExistingFile())
+	})
+
+	It("should not apply changes through symlinked directories", func() {
+		outside, err := ioutil.TempDir("", "provisioner-test")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(outside)
+		Expect(ioutil.WriteFile(filepath.Join(outside, "app.conf"), []byte("x"), 0644)).To(Succeed())
+
+		Expect(os.Mkdir(filepath.Join(dir, "conf"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "conf", "app.conf"), []byte("x"), 0644)).To(Succeed())
+		layout.Nodes = &[]beacon.LayoutNode{layoutNode("symlink", "conf/app.conf", "../app.conf")}
+		plan, err := p.PlanLayout(layout, data)
+		Expect(err).ToNot(HaveOccurred())
+
+		// conf is replaced by a symlink to a directory with the same
+		// content, so the plan isn't stale but would delete app.conf in it.
+		Expect(os.RemoveAll(filepath.Join(dir, "conf"))).To(Succeed())
+		Expect(os.Symlink(outside, filepath.Join(dir, "conf"))).To(Succeed())
+		Expect(p.ApplyPlan(context.Background(), plan)).To(MatchError(ContainSubstring("conf is a symlink")))
+		Expect(readFile(filepath.Join(outside, "app.conf"))).To(Equal("x"))
+
+		_, err = p.PlanLayout(layout, data)
+		Expect(err).To(MatchError(ContainSubstring("conf is a symlink")))
+	})
+
+	It("should split distant changes into hunks", func() {
+		layout.Nodes = &[]beacon.LayoutNode{layoutNode("", "app.conf", "A\nb\nc\nd\ne\nf\ng\nh\ni\nport=80\n")}
+		plan, err := p.PlanLayout(layout, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Actions).To(HaveLen(1))
+		Expect(plan.Actions[0].Diff).To(Equal("--- a/app.conf\n+++ b/app.conf\n" +
+			"@@ -1,4 +1,4 @@\n-a\n+A\n b\n c\n d\n"))
+
+		layout.Nodes = &[]beacon.LayoutNode{layoutNode("", "app.conf", "A\nb\nc\nd\ne\nf\ng\nh\ni\nport=1\n")}
+		plan, err = p.PlanLayout(layout, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Actions[0].Diff).To(Equal("--- a/app.conf\n+++ b/app.conf\n" +
+			"@@ -1,4 +1,4 @@\n-a\n+A\n b\n c\n d\n" +
+			"@@ -7,4 +7,4 @@\n g\n h\n i\n-port=80\n+port=1\n"))
+	})
+})
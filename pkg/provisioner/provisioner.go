@@ -0,0 +1,281 @@
+// Package provisioner runs the provisioning tasks of beacon features locally.
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/naveego/beacon-go/pkg/beacon"
+)
+
+// Options configures a Provisioner.
+type Options struct {
+	// Dir is the directory layouts are rendered into. It must be an
+	// absolute path.
+	Dir string
+	// Keyring decrypts encrypted config values, if set.
+	Keyring *beacon.Keyring
+	// Registry contains the handlers of named tasks. Tasks whose TaskName
+	// has no handler are rendered from their Layout.
+	Registry *TaskRegistry
+	// System, if set, records the progress of each run in an expectation
+	// with the workflow behavior.
+	System beacon.RunningSystem
+	// Log receives a message for each node written. Defaults to beacon.EmptyLog.
+	Log beacon.Log
+}
+
+// defaultTaskDeadline is the deadline of each task reported to the workflow
+// expectation when the feature has no ProvisioningTimeoutMS.
+const defaultTaskDeadline = 5 * time.Minute
+
+// Provisioner runs the ProvisioningTasks and UnprovisioningTasks of a Feature
+// for one of its instances.
+type Provisioner struct {
+	options Options
+}
+
+// New returns a Provisioner.
+func New(options Options) *Provisioner {
+	if options.Log == nil {
+		options.Log = beacon.EmptyLog{}
+	}
+	return &Provisioner{options: options}
+}
+
+// TemplateData is the data available to the templates of a layout.
+type TemplateData struct {
+	// Config is the instance's config merged over the feature's config,
+	// with references resolved and values decrypted.
+	Config interface{}
+	// Values are the TaskSpec's Values.
+	Values   interface{}
+	Instance InstanceData
+}
+
+// InstanceData is the metadata of the FeatureInstance being provisioned.
+type InstanceData struct {
+	Path           string
+	FeatureName    string
+	FeatureVersion string
+	InstanceName   string
+	Tenant         string
+	Labels         interface{}
+}
+
+// Provision runs the feature's ProvisioningTasks for instance, in order.
+// If a task fails, each task which completed is undone in reverse order,
+// as described by rollback.
+func (p *Provisioner) Provision(ctx context.Context, feature beacon.Feature, instance beacon.FeatureInstance) error {
+	if feature.ProvisioningTasks == nil {
+		return nil
+	}
+	return p.run(ctx, false, feature, instance, *feature.ProvisioningTasks)
+}
+
+// Unprovision runs the feature's UnprovisioningTasks for instance, in order.
+func (p *Provisioner) Unprovision(ctx context.Context, feature beacon.Feature, instance beacon.FeatureInstance) error {
+	if feature.UnprovisioningTasks == nil {
+		return nil
+	}
+	return p.run(ctx, true, feature, instance, *feature.UnprovisioningTasks)
+}
+
+// run runs tasks in order, within the feature's ProvisioningTimeoutMS.
+func (p *Provisioner) run(ctx context.Context, unprovision bool, feature beacon.Feature, instance beacon.FeatureInstance, tasks []beacon.TaskSpec) error {
+	action := "provisioning"
+	if unprovision {
+		action = "unprovisioning"
+	}
+
+	parent := ctx
+	timeout := time.Duration(to.Float64(feature.ProvisioningTimeoutMS)) * time.Millisecond
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	exp := p.expectation(action, instance)
+
+	config, err := p.config(feature, instance)
+	if err != nil {
+		err = fmt.Errorf("error %s: %s", action, err)
+		exp.Fail(beacon.RedactString(err.Error()))
+		return err
+	}
+	data := TemplateData{
+		Config:   config,
+		Instance: instanceData(instance),
+	}
+
+	for i, task := range tasks {
+		exp.Reschedule(fmt.Sprintf("running task %d of %d (%s)", i+1, len(tasks), taskName(task)), taskDeadline(ctx))
+
+		data.Values = task.Values
+		if err = ctx.Err(); err == nil {
+			err = p.runTask(ctx, unprovision, task, data)
+		}
+		if err == nil {
+			continue
+		}
+
+		if timeout > 0 && ctx.Err() == context.DeadlineExceeded {
+			p.logger(data.Instance.Path).Warn(action+" timed out", map[string]interface{}{"timeout": timeout.String()})
+			err = fmt.Errorf("error %s: timed out after %s", action, timeout)
+		} else {
+			err = fmt.Errorf("error %s: task %d (%s): %s", action, i, taskName(task), err)
+		}
+		if !unprovision {
+			if rollbackErr := p.rollback(parent, feature, tasks[:i], data); rollbackErr != nil {
+				err = fmt.Errorf("%s (%s)", err, rollbackErr)
+			}
+		}
+		exp.Fail(beacon.RedactString(err.Error()))
+		return err
+	}
+
+	exp.Fulfil(fmt.Sprintf("completed %d tasks", len(tasks)))
+	return nil
+}
+
+// rollback undoes completed tasks in reverse order, within a new
+// ProvisioningTimeoutMS. A task is undone by running its counterpart, the
+// UnprovisioningTask with the same TaskName, if there is one, and otherwise
+// by running its registered Unprovision func with its own Values. Tasks
+// which have neither are skipped. Rollback continues after a task fails to
+// be undone, and the errors are returned together.
+func (p *Provisioner) rollback(ctx context.Context, feature beacon.Feature, completed []beacon.TaskSpec, data TemplateData) error {
+	if timeout := time.Duration(to.Float64(feature.ProvisioningTimeoutMS)) * time.Millisecond; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	log := p.logger(data.Instance.Path)
+	var errs []string
+	for i := len(completed) - 1; i >= 0; i-- {
+		task := completed[i]
+		counterpart, ok := unprovisioningTask(feature, to.String(task.TaskName))
+		if !ok {
+			h, registered := p.options.Registry.lookup(to.String(task.TaskName))
+			if !registered || h.Unprovision == nil {
+				log.Debug("task cannot be rolled back", map[string]interface{}{"task": taskName(task)})
+				continue
+			}
+			counterpart = task
+		}
+		data.Values = counterpart.Values
+		if err := p.runTask(ctx, true, counterpart, data); err != nil {
+			errs = append(errs, fmt.Sprintf("error rolling back task %d (%s): %s", i, taskName(task), err))
+			continue
+		}
+		log.Debug("rolled back task", map[string]interface{}{"task": taskName(task)})
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// unprovisioningTask returns the feature's UnprovisioningTask named name.
+func unprovisioningTask(feature beacon.Feature, name string) (beacon.TaskSpec, bool) {
+	if name == "" || feature.UnprovisioningTasks == nil {
+		return beacon.TaskSpec{}, false
+	}
+	for _, task := range *feature.UnprovisioningTasks {
+		if to.String(task.TaskName) == name {
+			return task, true
+		}
+	}
+	return beacon.TaskSpec{}, false
+}
+
+// runTask runs task with its registered handler, or renders its layout.
+func (p *Provisioner) runTask(ctx context.Context, unprovision bool, task beacon.TaskSpec, data TemplateData) error {
+	if h, ok := p.options.Registry.lookup(to.String(task.TaskName)); ok {
+		return h.run(ctx, unprovision, task, data)
+	}
+	if task.Layout == nil {
+		return fmt.Errorf("no handler is registered for the task and it has no layout")
+	}
+	return p.RenderLayout(ctx, *task.Layout, data)
+}
+
+// expectation returns the workflow expectation recording the progress of a
+// run, or one which does nothing if Options.System is not set.
+func (p *Provisioner) expectation(action string, instance beacon.FeatureInstance) beacon.RunningExpectation {
+	if p.options.System == nil {
+		return noopExpectation{}
+	}
+	name := action
+	if instanceName := to.String(instance.InstanceName); instanceName != "" {
+		name = action + "-" + instanceName
+	}
+	return p.options.System.Expectation(beacon.ExpectationOptions{
+		Name:        name,
+		Description: fmt.Sprintf("%s of %s", action, to.String(instance.Path)),
+		Behavior:    beacon.Behavior1Workflow,
+	})
+}
+
+// taskDeadline returns the deadline of ctx, or defaultTaskDeadline from now.
+func taskDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(defaultTaskDeadline)
+}
+
+type noopExpectation struct{}
+
+func (noopExpectation) Fulfil(message string)                             {}
+func (noopExpectation) Fail(message string)                               {}
+func (noopExpectation) Reschedule(message string, rescheduleTo time.Time) {}
+func (noopExpectation) Retire()                                           {}
+
+// config returns the effective config of instance.
+func (p *Provisioner) config(feature beacon.Feature, instance beacon.FeatureInstance) (interface{}, error) {
+	effective, err := beacon.NewEffectiveConfig(feature, instance)
+	if err != nil {
+		return nil, err
+	}
+	config, err := beacon.ResolveConfig(effective.Config)
+	if err != nil {
+		return nil, err
+	}
+	if p.options.Keyring != nil {
+		if config, err = p.options.Keyring.DecryptConfig(config); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// logger returns a log scoped to the FeatureInstance at path.
+func (p *Provisioner) logger(path string) beacon.ScopedLog {
+	source, _ := beacon.ParseNRN(path)
+	return beacon.NewScopedLog(p.options.Log, source)
+}
+
+func instanceData(instance beacon.FeatureInstance) InstanceData {
+	return InstanceData{
+		Path:           to.String(instance.Path),
+		FeatureName:    to.String(instance.FeatureName),
+		FeatureVersion: to.String(instance.FeatureVersion),
+		InstanceName:   to.String(instance.InstanceName),
+		Tenant:         to.String(instance.Tenant),
+		Labels:         instance.Labels,
+	}
+}
+
+func taskName(task beacon.TaskSpec) string {
+	if name := to.String(task.TaskName); name != "" {
+		return name
+	}
+	return "unnamed"
+}
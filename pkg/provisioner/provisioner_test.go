@@ -0,0 +1,167 @@
+package provisioner_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/naveego/beacon-go/pkg/beacon"
+	. "github.com/naveego/beacon-go/pkg/provisioner"
+)
+
+func layoutNode(nodeType, pathTemplate, contentTemplate string) beacon.LayoutNode {
+	return beacon.LayoutNode{
+		Type:            to.StringPtr(nodeType),
+		PathTemplate:    to.StringPtr(pathTemplate),
+		ContentTemplate: to.StringPtr(contentTemplate),
+	}
+}
+
+func readFile(path string) string {
+	b, err := ioutil.ReadFile(path)
+	Expect(err).ToNot(HaveOccurred())
+	return string(b)
+}
+
+var _ = Describe("Provisioner", func() {
+
+	var (
+		dir      string
+		p        *Provisioner
+		feature  beacon.Feature
+		instance beacon.FeatureInstance
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "provisioner-test")
+		Expect(err).ToNot(HaveOccurred())
+		p = New(Options{Dir: dir})
+
+		feature = beacon.Feature{
+			Config: map[string]interface{}{"port": 8080, "host": "localhost"},
+			ProvisioningTasks: &[]beacon.TaskSpec{{
+				TaskName: to.StringPtr("layout"),
+				Values:   map[string]interface{}{"owner": "ops"},
+				Layout: &beacon.Layout{
+					Path:    to.StringPtr("{{.Instance.InstanceName}}"),
+					Exclude: to.StringPtr("**/*.bak"),
+					Nodes: &[]beacon.LayoutNode{
+						layoutNode("", "conf/app.conf", "host={{.Config.host}}\nport={{.Config.port}}\nowner={{.Values.owner}}\n"),
+						layoutNode("dir", "data/{{.Instance.Tenant}}", ""),
+						layoutNode("symlink", "current", "conf"),
+						layoutNode("file", "conf/app.conf.bak", "old"),
+						layoutNode("file", "labels.json", "{{json .Instance.Labels}}"),
+					},
+				},
+			}},
+			UnprovisioningTasks: &[]beacon.TaskSpec{{
+				Layout: &beacon.Layout{
+					Nodes: &[]beacon.LayoutNode{layoutNode("", "{{.Instance.InstanceName}}/conf/app.conf", "removed")},
+				},
+			}},
+		}
+		instance = beacon.FeatureInstance{
+			Path:         to.StringPtr("nrn:beacon:acme:fin::orders/1.0.0/east"),
+			InstanceName: to.StringPtr("east"),
+			Tenant:       to.StringPtr("acme"),
+			Labels:       map[string]interface{}{"region": "us-east"},
+			Config:       map[string]interface{}{"host": "orders.example.com"},
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should render layouts with the instance's config, the task's values and instance metadata", func() {
+		Expect(p.Provision(context.Background(), feature, instance)).To(Succeed())
+
+		root := filepath.Join(dir, "east")
+		Expect(readFile(filepath.Join(root, "conf", "app.conf"))).To(Equal("host=orders.example.com\nport=8080\nowner=ops\n"))
+		Expect(readFile(filepath.Join(root, "labels.json"))).To(Equal(`{"region":"us-east"}`))
+		Expect(filepath.Join(root, "data", "acme")).To(BeADirectory())
+		Expect(os.Readlink(filepath.Join(root, "current"))).To(Equal("conf"))
+		Expect(filepath.Join(root, "conf", "app.conf.bak")).ToNot(BeAnExistingFile())
+
+		// Rendering again replaces what was written.
+		Expect(p.Provision(context.Background(), feature, instance)).To(Succeed())
+		Expect(p.Unprovision(context.Background(), feature, instance)).To(Succeed())
+		Expect(readFile(filepath.Join(root, "conf", "app.conf"))).To(Equal("removed"))
+	})
+
+	It("should only render included nodes", func() {
+		(*feature.ProvisioningTasks)[0].Layout.Include = to.StringPtr("conf/*, current")
+		Expect(p.Provision(context.Background(), feature, instance)).To(Succeed())
+
+		root := filepath.Join(dir, "east")
+		Expect(filepath.Join(root, "conf", "app.conf")).To(BeAnExistingFile())
+		Expect(filepath.Join(root, "current")).To(BeAnExistingFile())
+		Expect(filepath.Join(root, "labels.json")).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(root, "data")).ToNot(BeAnExistingFile())
+	})
+
+	It("should not write anything if a node cannot be rendered", func() {
+		nodes := (*feature.ProvisioningTasks)[0].Layout.Nodes
+		*nodes = append(*nodes, layoutNode("", "missing", "{{.Config.missing}}"))
+
+		err := p.Provision(context.Background(), feature, instance)
+		Expect(err).To(MatchError(ContainSubstring("task 0 (layout): node 5")))
+		Expect(filepath.Join(dir, "east")).ToNot(BeAnExistingFile())
+	})
+
+	It("should reject paths outside the directory", func() {
+		(*feature.ProvisioningTasks)[0].Layout.Nodes = &[]beacon.LayoutNode{layoutNode("", "../../escape", "x")}
+		Expect(p.Provision(context.Background(), feature, instance)).To(MatchError(ContainSubstring("outside the layout")))
+
+		(*feature.ProvisioningTasks)[0].Layout.Nodes = &[]beacon.LayoutNode{layoutNode("pipe", "x", "")}
+		Expect(p.Provision(context.Background(), feature, instance)).To(MatchError(ContainSubstring(`unknown type "pipe"`)))
+	})
+
+	It("should not write through symlinks outside the directory", func() {
+		outside, err := ioutil.TempDir("", "provisioner-test")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(outside)
+
+		for _, target := range []string{outside, "../../escape", "conf/../../.."} {
+			(*feature.ProvisioningTasks)[0].Layout.Nodes = &[]beacon.LayoutNode{layoutNode("symlink", "conf/link", target)}
+			Expect(p.Provision(context.Background(), feature, instance)).To(MatchError(ContainSubstring("outside the layout")), target)
+		}
+
+		Expect(os.MkdirAll(filepath.Join(dir, "east"), 0755)).To(Succeed())
+		Expect(os.Symlink(outside, filepath.Join(dir, "east", "conf"))).To(Succeed())
+		(*feature.ProvisioningTasks)[0].Layout.Nodes = &[]beacon.LayoutNode{layoutNode("", "conf/app.conf", "x")}
+		Expect(p.Provision(context.Background(), feature, instance)).To(MatchError(ContainSubstring("conf is a symlink")))
+		Expect(filepath.Join(outside, "app.conf")).ToNot(BeAnExistingFile())
+	})
+
+	It("should not write through a symlinked layout path", func() {
+		outside, err := ioutil.TempDir("", "provisioner-test")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(outside)
+
+		Expect(os.Symlink(outside, filepath.Join(dir, "east"))).To(Succeed())
+		Expect(p.Provision(context.Background(), feature, instance)).To(MatchError(ContainSubstring("east is a symlink")))
+		Expect(filepath.Join(outside, "conf")).ToNot(BeADirectory())
+	})
+
+	It("should require an absolute directory", func() {
+		for _, d := range []string{"", "relative/dir"} {
+			p = New(Options{Dir: d})
+			Expect(p.Provision(context.Background(), feature, instance)).To(MatchError(ContainSubstring("must be an absolute path")), d)
+		}
+	})
+
+	It("should finish within the provisioning timeout", func() {
+		feature.ProvisioningTimeoutMS = to.Float64Ptr(1)
+		ctx, cancel := context.WithTimeout(context.Background(), 0)
+		defer cancel()
+		Expect(p.Provision(ctx, feature, instance)).To(MatchError("error provisioning: timed out after 1ms"))
+		Expect(filepath.Join(dir, "east")).ToNot(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,135 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/naveego/beacon-go/pkg/beacon"
+)
+
+// Task is a provisioning task passed to a TaskHandler.
+type Task struct {
+	Name string
+	// Values are the TaskSpec's Values decoded into a pointer to a new value
+	// of the type registered with the handler, or nil if no type was registered.
+	Values interface{}
+	// Layout is the TaskSpec's Layout, if any.
+	Layout *beacon.Layout
+	// Data contains the config, raw values and instance metadata of the task.
+	Data TemplateData
+}
+
+// TaskFunc runs a provisioning task.
+type TaskFunc func(ctx context.Context, task Task) error
+
+// TaskHandler provisions and unprovisions a named task.
+type TaskHandler struct {
+	// Values is a struct, or a pointer to a struct, of the type the task's
+	// Values are decoded into with beacon.BindConfig, so defaults and
+	// validation work as they do for config. Unknown keys are an error. If
+	// Values is nil, Task.Values is nil.
+	Values interface{}
+	// Provision runs the task when it is one of the ProvisioningTasks.
+	Provision TaskFunc
+	// Unprovision runs the task when it is one of the UnprovisioningTasks,
+	// and undoes Provision when a later task fails. It is optional.
+	Unprovision TaskFunc
+}
+
+// TaskRegistry contains the handlers of named provisioning tasks.
+type TaskRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]registeredHandler
+}
+
+type registeredHandler struct {
+	TaskHandler
+	valuesType reflect.Type
+}
+
+// NewTaskRegistry returns an empty TaskRegistry.
+func NewTaskRegistry() *TaskRegistry {
+	return &TaskRegistry{handlers: make(map[string]registeredHandler)}
+}
+
+// Register registers handler for tasks whose TaskName is name.
+func (r *TaskRegistry) Register(name string, handler TaskHandler) error {
+	if handler.Provision == nil {
+		return fmt.Errorf("task %q has no Provision func", name)
+	}
+	var valuesType reflect.Type
+	if handler.Values != nil {
+		valuesType = reflect.TypeOf(handler.Values)
+		if valuesType.Kind() == reflect.Ptr {
+			valuesType = valuesType.Elem()
+		}
+		if valuesType.Kind() != reflect.Struct {
+			return fmt.Errorf("values of task %q must be a struct, got %T", name, handler.Values)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.handlers[name]; ok {
+		return fmt.Errorf("a handler is already registered for task %q", name)
+	}
+	r.handlers[name] = registeredHandler{TaskHandler: handler, valuesType: valuesType}
+	return nil
+}
+
+// MustRegister is like Register but panics if the handler cannot be registered.
+func (r *TaskRegistry) MustRegister(name string, handler TaskHandler) {
+	if err := r.Register(name, handler); err != nil {
+		panic(err)
+	}
+}
+
+// Names returns the names of the registered tasks, sorted.
+func (r *TaskRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *TaskRegistry) lookup(name string) (registeredHandler, bool) {
+	if r == nil {
+		return registeredHandler{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[name]
+	return h, ok
+}
+
+// run runs Provision, or Unprovision if unprovision is true, with spec's
+// Values decoded.
+func (h registeredHandler) run(ctx context.Context, unprovision bool, spec beacon.TaskSpec, data TemplateData) error {
+	fn := h.Provision
+	if unprovision {
+		if fn = h.Unprovision; fn == nil {
+			return fmt.Errorf("task cannot be unprovisioned")
+		}
+	}
+
+	task := Task{
+		Name:   taskName(spec),
+		Layout: spec.Layout,
+		Data:   data,
+	}
+	if h.valuesType != nil {
+		values := reflect.New(h.valuesType).Interface()
+		if err := beacon.BindConfig(spec.Values, values, beacon.BindOptions{ErrorUnused: true}); err != nil {
+			return fmt.Errorf("invalid values: %s", err)
+		}
+		task.Values = values
+	}
+	return fn(ctx, task)
+}
@@ -0,0 +1,212 @@
+package provisioner_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/naveego/beacon-go/pkg/beacon"
+	. "github.com/naveego/beacon-go/pkg/provisioner"
+)
+
+// recordingSystem records the reports made to its expectations.
+type recordingSystem struct {
+	mu      sync.Mutex
+	options []beacon.ExpectationOptions
+	reports []string
+}
+
+func (r *recordingSystem) Child(options beacon.SystemOptions) beacon.RunningSystem { return r }
+func (r *recordingSystem) Shutdown()                                               {}
+
+func (r *recordingSystem) Expectation(options beacon.ExpectationOptions) beacon.RunningExpectation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.options = append(r.options, options)
+	return &recordingExpectation{system: r}
+}
+
+func (r *recordingSystem) record(report string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, report)
+}
+
+type recordingExpectation struct {
+	system *recordingSystem
+}
+
+func (e *recordingExpectation) Fulfil(message string) { e.system.record("fulfil: " + message) }
+func (e *recordingExpectation) Fail(message string)   { e.system.record("fail: " + message) }
+func (e *recordingExpectation) Retire()               { e.system.record("retire") }
+func (e *recordingExpectation) Reschedule(message string, rescheduleTo time.Time) {
+	e.system.record("reschedule: " + message)
+}
+
+type databaseValues struct {
+	Name  string
+	Owner string `default:"admin"`
+}
+
+var _ = Describe("TaskRegistry", func() {
+
+	var (
+		dir      string
+		registry *TaskRegistry
+		system   *recordingSystem
+		p        *Provisioner
+		calls    []string
+		instance beacon.FeatureInstance
+	)
+
+	record := func(action string, err error) TaskFunc {
+		return func(ctx context.Context, task Task) error {
+			calls = append(calls, fmt.Sprintf("%s %s %+v", action, task.Name, task.Values))
+			return err
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "provisioner-test")
+		Expect(err).ToNot(HaveOccurred())
+
+		calls = nil
+		registry = NewTaskRegistry()
+		registry.MustRegister("create-database", TaskHandler{
+			Values:      databaseValues{},
+			Provision:   record("create", nil),
+			Unprovision: record("drop", nil),
+		})
+		registry.MustRegister("register-queue", TaskHandler{
+			Provision:   record("register", nil),
+			Unprovision: record("unregister", nil),
+		})
+		registry.MustRegister("notify", TaskHandler{Provision: record("notify", nil)})
+		registry.MustRegister("fail", TaskHandler{Provision: record("fail", errors.New("boom"))})
+		system = &recordingSystem{}
+		p = New(Options{Dir: dir, Registry: registry, System: system})
+
+		instance = beacon.FeatureInstance{
+			Path:         to.StringPtr("nrn:beacon:acme:fin::orders/1.0.0/east"),
+			InstanceName: to.StringPtr("east"),
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	feature := func(last string) beacon.Feature {
+		return beacon.Feature{
+			ProvisioningTasks: &[]beacon.TaskSpec{
+				{TaskName: to.StringPtr("create-database"), Values: map[string]interface{}{"name": "orders"}},
+				{TaskName: to.StringPtr("register-queue")},
+				{TaskName: to.StringPtr("notify")},
+				{TaskName: to.StringPtr("write-config"), Layout: &beacon.Layout{
+					Nodes: &[]beacon.LayoutNode{layoutNode("", "config", "x")},
+				}},
+				{TaskName: to.StringPtr(last)},
+			},
+			UnprovisioningTasks: &[]beacon.TaskSpec{
+				{TaskName: to.StringPtr("write-config"), Layout: &beacon.Layout{
+					Nodes: &[]beacon.LayoutNode{layoutNode("", "config", "removed")},
+				}},
+				{TaskName: to.StringPtr("create-database"), Values: map[string]interface{}{"name": "orders", "owner": "root"}},
+			},
+		}
+	}
+
+	It("should reject invalid registrations", func() {
+		Expect(registry.Register("create-database", TaskHandler{Provision: record("", nil)})).To(MatchError(`a handler is already registered for task "create-database"`))
+		Expect(registry.Register("other", TaskHandler{Values: "", Provision: record("", nil)})).To(MatchError(`values of task "other" must be a struct, got string`))
+		Expect(registry.Register("other", TaskHandler{})).To(MatchError(`task "other" has no Provision func`))
+		Expect(registry.Names()).To(Equal([]string{"create-database", "fail", "notify", "register-queue"}))
+	})
+
+	It("should run tasks in order with typed values and record progress", func() {
+		Expect(p.Provision(context.Background(), feature("notify"), instance)).To(Succeed())
+
+		Expect(calls).To(Equal([]string{
+			"create create-database &{Name:orders Owner:admin}",
+			"register register-queue <nil>",
+			"notify notify <nil>",
+			"notify notify <nil>",
+		}))
+		Expect(readFile(filepath.Join(dir, "config"))).To(Equal("x"))
+
+		Expect(system.options).To(HaveLen(1))
+		Expect(system.options[0].Name).To(Equal("provisioning-east"))
+		Expect(system.options[0].Behavior).To(Equal(beacon.Behavior1Workflow))
+		Expect(system.reports).To(Equal([]string{
+			"reschedule: running task 1 of 5 (create-database)",
+			"reschedule: running task 2 of 5 (register-queue)",
+			"reschedule: running task 3 of 5 (notify)",
+			"reschedule: running task 4 of 5 (write-config)",
+			"reschedule: running task 5 of 5 (notify)",
+			"fulfil: completed 5 tasks",
+		}))
+
+		calls = nil
+		Expect(p.Unprovision(context.Background(), feature("notify"), instance)).To(Succeed())
+		Expect(calls).To(Equal([]string{"drop create-database &{Name:orders Owner:root}"}))
+		Expect(readFile(filepath.Join(dir, "config"))).To(Equal("removed"))
+	})
+
+	It("should roll back completed tasks in reverse order on failure", func() {
+		err := p.Provision(context.Background(), feature("fail"), instance)
+		Expect(err).To(MatchError("error provisioning: task 4 (fail): boom"))
+
+		Expect(calls).To(Equal([]string{
+			"create create-database &{Name:orders Owner:admin}",
+			"register register-queue <nil>",
+			"notify notify <nil>",
+			"fail fail <nil>",
+			// Counterparts run with their own values; tasks without one
+			// are undone with their own values.
+			"unregister register-queue <nil>",
+			"drop create-database &{Name:orders Owner:root}",
+		}))
+		Expect(readFile(filepath.Join(dir, "config"))).To(Equal("removed"))
+		Expect(system.reports[len(system.reports)-1]).To(Equal("fail: error provisioning: task 4 (fail): boom"))
+	})
+
+	It("should keep rolling back when a task cannot be undone", func() {
+		registry.MustRegister("flaky-queue", TaskHandler{
+			Provision:   record("register", nil),
+			Unprovision: record("unregister", errors.New("queue is gone")),
+		})
+		f := feature("fail")
+		(*f.ProvisioningTasks)[1].TaskName = to.StringPtr("flaky-queue")
+
+		err := p.Provision(context.Background(), f, instance)
+		Expect(err).To(MatchError("error provisioning: task 4 (fail): boom (error rolling back task 1 (flaky-queue): queue is gone)"))
+		Expect(calls[len(calls)-2:]).To(Equal([]string{
+			"unregister flaky-queue <nil>",
+			"drop create-database &{Name:orders Owner:root}",
+		}))
+		Expect(readFile(filepath.Join(dir, "config"))).To(Equal("removed"))
+	})
+
+	It("should fail tasks with invalid values or no handler", func() {
+		f := beacon.Feature{ProvisioningTasks: &[]beacon.TaskSpec{
+			{TaskName: to.StringPtr("create-database"), Values: map[string]interface{}{"nmae": "orders"}},
+		}}
+		Expect(p.Provision(context.Background(), f, instance)).To(MatchError(ContainSubstring("task 0 (create-database): invalid values")))
+
+		f = beacon.Feature{UnprovisioningTasks: &[]beacon.TaskSpec{{TaskName: to.StringPtr("notify")}}}
+		Expect(p.Unprovision(context.Background(), f, instance)).To(MatchError(ContainSubstring("task cannot be unprovisioned")))
+
+		f = beacon.Feature{ProvisioningTasks: &[]beacon.TaskSpec{{TaskName: to.StringPtr("unknown")}}}
+		Expect(p.Provision(context.Background(), f, instance)).To(MatchError(ContainSubstring("no handler is registered")))
+	})
+})